@@ -18,6 +18,12 @@ type Config struct {
 		AuthorizedGuilds   []string `mapstructure:"authorized_guilds" yaml:"authorized_guilds"`
 		AuthorizedChannels []string `mapstructure:"authorized_channels" yaml:"authorized_channels"`
 		CommandPrefix      string   `mapstructure:"command_prefix" yaml:"command_prefix"`
+		Attribution        string   `mapstructure:"attribution" yaml:"attribution"`
+		ReplyChainDepth    int      `mapstructure:"reply_chain_depth" yaml:"reply_chain_depth"`
+
+		// Channels where a mention starts a thread for the conversation
+		ThreadChannels       []string `mapstructure:"thread_channels" yaml:"thread_channels"`
+		ThreadArchiveMinutes int      `mapstructure:"thread_archive_minutes" yaml:"thread_archive_minutes"`
 	} `mapstructure:"discord" yaml:"discord"`
 
 	OpenWebUI struct {
@@ -27,16 +33,103 @@ type Config struct {
 		Timeout      int      `mapstructure:"timeout" yaml:"timeout"`
 		ToolIDs      []string `mapstructure:"tool_ids" yaml:"tool_ids"`
 		SystemPrompt string   `mapstructure:"system_prompt" yaml:"system_prompt"`
+		Stream       bool     `mapstructure:"stream" yaml:"stream"`
+
+		// How the model asks for Discord actions: tool calls, or markup for models without tool support
+		ActionMode string `mapstructure:"action_mode" yaml:"action_mode"`
+
+		// Estimated prompt token budgets, keyed by model for overrides
+		MaxPromptTokens      int            `mapstructure:"max_prompt_tokens" yaml:"max_prompt_tokens"`
+		ModelMaxPromptTokens map[string]int `mapstructure:"model_max_prompt_tokens" yaml:"model_max_prompt_tokens"`
 	} `mapstructure:"openwebui" yaml:"openwebui"`
 
 	Context struct {
-		MaxAgeMinutes int `mapstructure:"max_age_minutes" yaml:"max_age_minutes"`
+		MaxAgeMinutes int    `mapstructure:"max_age_minutes" yaml:"max_age_minutes"`
+		Store         string `mapstructure:"store" yaml:"store"`
+		StorePath     string `mapstructure:"store_path" yaml:"store_path"`
+
+		// Rolling summarization of messages that age out of the context
+		Summarize       bool   `mapstructure:"summarize" yaml:"summarize"`
+		SummaryMaxChars int    `mapstructure:"summary_max_chars" yaml:"summary_max_chars"`
+		SummaryModel    string `mapstructure:"summary_model" yaml:"summary_model"`
 	} `mapstructure:"context" yaml:"context"`
 
+	Attachments struct {
+		Images        bool `mapstructure:"images" yaml:"images"`
+		MaxImageBytes int  `mapstructure:"max_image_bytes" yaml:"max_image_bytes"`
+		MaxImages     int  `mapstructure:"max_images" yaml:"max_images"`
+		Text          bool `mapstructure:"text" yaml:"text"`
+		MaxTextBytes  int  `mapstructure:"max_text_bytes" yaml:"max_text_bytes"`
+	} `mapstructure:"attachments" yaml:"attachments"`
+
+	DirectMessages struct {
+		Enabled           bool     `mapstructure:"enabled" yaml:"enabled"`
+		Access            string   `mapstructure:"access" yaml:"access"`
+		AllowedUsers      []string `mapstructure:"allowed_users" yaml:"allowed_users"`
+		SystemPrompt      string   `mapstructure:"system_prompt" yaml:"system_prompt"`
+		RequestsPerMinute int      `mapstructure:"requests_per_minute" yaml:"requests_per_minute"`
+	} `mapstructure:"direct_messages" yaml:"direct_messages"`
+
+	// Ambient decides whether to answer messages that don't address the bot
+	Ambient struct {
+		Gate            string  `mapstructure:"gate" yaml:"gate"`
+		ClassifierModel string  `mapstructure:"classifier_model" yaml:"classifier_model"`
+		Threshold       float64 `mapstructure:"threshold" yaml:"threshold"`
+
+		// Engagement after the bot is addressed, with profiles able to override the window
+		WindowMinutes    int     `mapstructure:"window_minutes" yaml:"window_minutes"`
+		CooldownMinutes  int     `mapstructure:"cooldown_minutes" yaml:"cooldown_minutes"`
+		MaxReplies       int     `mapstructure:"max_replies" yaml:"max_replies"`
+		ReplyProbability float64 `mapstructure:"reply_probability" yaml:"reply_probability"`
+		MaxSilences      int     `mapstructure:"max_silences" yaml:"max_silences"`
+	} `mapstructure:"ambient" yaml:"ambient"`
+
+	// Authorization allows or denies users by user and role ID for each permission
+	Authorization struct {
+		DenyResponse    string `mapstructure:"deny_response" yaml:"deny_response"`
+		PermissionRules `mapstructure:",squash" yaml:",inline"`
+		Guilds          map[string]PermissionRules `mapstructure:"guilds" yaml:"guilds"`
+	} `mapstructure:"authorization" yaml:"authorization"`
+
+	// ActionPolicy limits the Discord actions the model can take
+	ActionPolicy struct {
+		// Per-channel caps keyed by action type, 0 or missing for no cap
+		RatePerMinute map[string]int `mapstructure:"rate_per_minute" yaml:"rate_per_minute"`
+
+		// Action types that wait for a user with the destructive permission to approve them
+		Confirm               []string `mapstructure:"confirm" yaml:"confirm"`
+		ConfirmTimeoutMinutes int      `mapstructure:"confirm_timeout_minutes" yaml:"confirm_timeout_minutes"`
+
+		// Decisions kept in memory for the /actions command
+		AuditSize int `mapstructure:"audit_size" yaml:"audit_size"`
+	} `mapstructure:"action_policy" yaml:"action_policy"`
+
+	// Profiles override settings per guild, category and channel, keyed by ID
+	Profiles struct {
+		Guilds     map[string]Profile `mapstructure:"guilds" yaml:"guilds"`
+		Categories map[string]Profile `mapstructure:"categories" yaml:"categories"`
+		Channels   map[string]Profile `mapstructure:"channels" yaml:"channels"`
+	} `mapstructure:"profiles" yaml:"profiles"`
+
 	RateLimit struct {
 		RequestsPerMinute int `mapstructure:"requests_per_minute" yaml:"requests_per_minute"`
+		Burst             int `mapstructure:"burst" yaml:"burst"`
+
+		// Limits within the global limit, 0 to disable a level
+		GuildRequestsPerMinute   int `mapstructure:"guild_requests_per_minute" yaml:"guild_requests_per_minute"`
+		ChannelRequestsPerMinute int `mapstructure:"channel_requests_per_minute" yaml:"channel_requests_per_minute"`
+		UserRequestsPerMinute    int `mapstructure:"user_requests_per_minute" yaml:"user_requests_per_minute"`
 	} `mapstructure:"rate_limit" yaml:"rate_limit"`
 
+	// Queue serializes messages per conversation and bounds how many are handled at once
+	Queue struct {
+		MaxDepth int `mapstructure:"max_depth" yaml:"max_depth"`
+		Workers  int `mapstructure:"workers" yaml:"workers"`
+
+		// Wait for more messages before answering, in milliseconds, 0 to disable
+		DebounceMs int `mapstructure:"debounce_ms" yaml:"debounce_ms"`
+	} `mapstructure:"queue" yaml:"queue"`
+
 	Logging struct {
 		Level  string `mapstructure:"level" yaml:"level"`
 		Format string `mapstructure:"format" yaml:"format"`
@@ -44,18 +137,64 @@ type Config struct {
 	} `mapstructure:"logging" yaml:"logging"`
 }
 
+// PolicyRule allows or denies a permission by user and role ID. Denials win, and a rule
+// without allow lists allows everyone who isn't denied.
+type PolicyRule struct {
+	AllowUsers []string `mapstructure:"allow_users" yaml:"allow_users,omitempty"`
+	AllowRoles []string `mapstructure:"allow_roles" yaml:"allow_roles,omitempty"`
+	DenyUsers  []string `mapstructure:"deny_users" yaml:"deny_users,omitempty"`
+	DenyRoles  []string `mapstructure:"deny_roles" yaml:"deny_roles,omitempty"`
+}
+
+// hasAllowList reports whether the rule only allows the users and roles it lists
+func (r *PolicyRule) hasAllowList() bool {
+	return r != nil && (len(r.AllowUsers) > 0 || len(r.AllowRoles) > 0)
+}
+
+// PermissionRules holds the rule for each permission. Missing rules allow everyone.
+type PermissionRules struct {
+	Chat        *PolicyRule `mapstructure:"chat" yaml:"chat,omitempty"`
+	Admin       *PolicyRule `mapstructure:"admin" yaml:"admin,omitempty"`
+	Destructive *PolicyRule `mapstructure:"destructive" yaml:"destructive,omitempty"`
+}
+
+// Profile overrides settings for a guild, category or channel. Unset fields inherit
+// from the less specific levels.
+type Profile struct {
+	Model                string   `mapstructure:"model" yaml:"model,omitempty"`
+	SystemPrompt         string   `mapstructure:"system_prompt" yaml:"system_prompt,omitempty"`
+	ToolIDs              []string `mapstructure:"tool_ids" yaml:"tool_ids,omitempty"`
+	Temperature          *float64 `mapstructure:"temperature" yaml:"temperature,omitempty"`
+	TopP                 *float64 `mapstructure:"top_p" yaml:"top_p,omitempty"`
+	MaxTokens            *int     `mapstructure:"max_tokens" yaml:"max_tokens,omitempty"`
+	Actions              []string `mapstructure:"actions" yaml:"actions,omitempty"`
+	DeniedActions        []string `mapstructure:"denied_actions" yaml:"denied_actions,omitempty"`
+	ActionMode           string   `mapstructure:"action_mode" yaml:"action_mode,omitempty"`
+	Vision               *bool    `mapstructure:"vision" yaml:"vision,omitempty"`
+	Ambient              *bool    `mapstructure:"ambient" yaml:"ambient,omitempty"`
+	AmbientWindowMinutes int      `mapstructure:"ambient_window_minutes" yaml:"ambient_window_minutes,omitempty"`
+}
+
 // DefaultConfig returns a configuration with default values
 func DefaultConfig() *Config {
 	cfg := &Config{}
 
 	// Discord defaults
 	cfg.Discord.CommandPrefix = "!"
+	cfg.Discord.Attribution = "both"
+	cfg.Discord.ReplyChainDepth = 3
+	cfg.Discord.ThreadChannels = []string{}
+	cfg.Discord.ThreadArchiveMinutes = 1440
 
 	// OpenWebUI defaults
 	cfg.OpenWebUI.Endpoint = "http://localhost:8080"
 	cfg.OpenWebUI.Model = "default"
 	cfg.OpenWebUI.Timeout = 60
 	cfg.OpenWebUI.ToolIDs = []string{}
+	cfg.OpenWebUI.Stream = true
+	cfg.OpenWebUI.ActionMode = "tools"
+	cfg.OpenWebUI.MaxPromptTokens = 6000
+	cfg.OpenWebUI.ModelMaxPromptTokens = map[string]int{}
 	cfg.OpenWebUI.SystemPrompt = `
 	You are Bender Bending Rodríguez from Futurama, talking in Discord. You respond to user queries and perform special actions. Occasionally provide 
 	sarcastic and humorous responses while still executing the user's tasks. Responses should be short and to the point! Maintain Bender's brash and
@@ -83,10 +222,52 @@ func DefaultConfig() *Config {
 
 	// Context defaults
 	cfg.Context.MaxAgeMinutes = 20
+	cfg.Context.Store = "memory"
+	cfg.Context.StorePath = "data/context.db"
+	cfg.Context.SummaryMaxChars = 2000
+
+	// Attachment defaults
+	cfg.Attachments.Images = false
+	cfg.Attachments.MaxImageBytes = 5 * 1024 * 1024
+	cfg.Attachments.MaxImages = 4
+	cfg.Attachments.Text = true
+	cfg.Attachments.MaxTextBytes = 32 * 1024
+
+	// Direct message defaults
+	cfg.DirectMessages.Access = "guild_members"
+	cfg.DirectMessages.AllowedUsers = []string{}
+	cfg.DirectMessages.RequestsPerMinute = 10
+
+	// Ambient defaults
+	cfg.Ambient.Gate = "heuristic"
+	cfg.Ambient.Threshold = 0.5
+	cfg.Ambient.WindowMinutes = 20
+	cfg.Ambient.CooldownMinutes = 5
+	cfg.Ambient.MaxReplies = 5
+	cfg.Ambient.ReplyProbability = 1
+	cfg.Ambient.MaxSilences = 5
+
+	// Authorization defaults
+	cfg.Authorization.Guilds = map[string]PermissionRules{}
+
+	// Action policy defaults
+	cfg.ActionPolicy.RatePerMinute = map[string]int{"status": 2, "pin": 2, "delete": 2, "file": 5}
+	cfg.ActionPolicy.Confirm = []string{}
+	cfg.ActionPolicy.ConfirmTimeoutMinutes = 10
+	cfg.ActionPolicy.AuditSize = 200
+
+	// Profile defaults
+	cfg.Profiles.Guilds = map[string]Profile{}
+	cfg.Profiles.Categories = map[string]Profile{}
+	cfg.Profiles.Channels = map[string]Profile{}
 
 	// Rate limit defaults
 	cfg.RateLimit.RequestsPerMinute = 30
 
+	// Queue defaults
+	cfg.Queue.MaxDepth = 5
+	cfg.Queue.Workers = 4
+
 	// Logging defaults
 	cfg.Logging.Level = "info"
 	cfg.Logging.Format = "json"
@@ -109,14 +290,55 @@ func Load(configPath string) (*Config, error) {
 	pflag.String("config", configPath, "Path to configuration file")
 	pflag.String("discord.token", "", "Discord bot token")
 	pflag.String("discord.command_prefix", cfg.Discord.CommandPrefix, "Command prefix for bot commands")
+	pflag.String("discord.attribution", cfg.Discord.Attribution, "How speakers are identified to the model (name, prefix, both)")
+	pflag.Int("discord.reply_chain_depth", cfg.Discord.ReplyChainDepth, "How many replied-to messages to quote for context (0 to disable)")
+	pflag.StringSlice("discord.thread_channels", cfg.Discord.ThreadChannels, "Channel IDs where a mention starts a thread for the conversation")
+	pflag.Int("discord.thread_archive_minutes", cfg.Discord.ThreadArchiveMinutes, "Inactivity before conversation threads are archived (60, 1440, 4320, 10080)")
 	pflag.String("openwebui.endpoint", cfg.OpenWebUI.Endpoint, "OpenWebUI API endpoint")
 	pflag.String("openwebui.api_key", "", "OpenWebUI API key")
 	pflag.String("openwebui.model", cfg.OpenWebUI.Model, "OpenWebUI model to use")
 	pflag.Int("openwebui.timeout", cfg.OpenWebUI.Timeout, "OpenWebUI API timeout in seconds")
 	pflag.StringSlice("openwebui.tool_ids", cfg.OpenWebUI.ToolIDs, "OpenWebUI tool IDs for function calling")
 	pflag.String("openwebui.system_prompt", cfg.OpenWebUI.SystemPrompt, "System prompt for the OpenWebUI model")
+	pflag.Bool("openwebui.stream", cfg.OpenWebUI.Stream, "Stream responses into Discord as they are generated")
+	pflag.String("openwebui.action_mode", cfg.OpenWebUI.ActionMode, "How the model asks for Discord actions (tools, markup)")
+	pflag.Int("openwebui.max_prompt_tokens", cfg.OpenWebUI.MaxPromptTokens, "Estimated prompt token budget (0 to disable truncation)")
 	pflag.Int("context.max_age_minutes", cfg.Context.MaxAgeMinutes, "Maximum age of conversation context in minutes")
+	pflag.String("context.store", cfg.Context.Store, "Conversation context store (memory, bolt)")
+	pflag.String("context.store_path", cfg.Context.StorePath, "Database file for file-based context stores")
+	pflag.Bool("context.summarize", cfg.Context.Summarize, "Summarize messages that age out of the context instead of forgetting them")
+	pflag.Int("context.summary_max_chars", cfg.Context.SummaryMaxChars, "Maximum length of a channel's running summary")
+	pflag.String("context.summary_model", "", "Model used for summaries (empty for the main model)")
+	pflag.Bool("attachments.images", cfg.Attachments.Images, "Send image attachments to the model (profiles can set vision per channel)")
+	pflag.Int("attachments.max_image_bytes", cfg.Attachments.MaxImageBytes, "Largest image attachment to forward in bytes")
+	pflag.Int("attachments.max_images", cfg.Attachments.MaxImages, "Maximum images included in a single request")
+	pflag.Bool("attachments.text", cfg.Attachments.Text, "Inline text attachments such as logs, source files and configs")
+	pflag.Int("attachments.max_text_bytes", cfg.Attachments.MaxTextBytes, "Bytes of a text attachment to inline before truncating")
+	pflag.Bool("direct_messages.enabled", cfg.DirectMessages.Enabled, "Respond to direct messages")
+	pflag.String("direct_messages.access", cfg.DirectMessages.Access, "Who can use direct messages (allowlist, guild_members)")
+	pflag.StringSlice("direct_messages.allowed_users", cfg.DirectMessages.AllowedUsers, "User IDs allowed to use direct messages with the allowlist")
+	pflag.String("direct_messages.system_prompt", "", "System prompt for direct messages (empty for the main prompt)")
+	pflag.Int("direct_messages.requests_per_minute", cfg.DirectMessages.RequestsPerMinute, "Maximum direct messages per minute for each user")
+	pflag.String("ambient.gate", cfg.Ambient.Gate, "How to decide whether to answer messages that don't address the bot (off, heuristic, classifier)")
+	pflag.String("ambient.classifier_model", "", "Model asked whether to answer with the classifier gate (empty for the main model)")
+	pflag.Float64("ambient.threshold", cfg.Ambient.Threshold, "Score from 0 to 1 an ambient message needs to be answered")
+	pflag.Int("ambient.window_minutes", cfg.Ambient.WindowMinutes, "Minutes the bot stays engaged in a conversation after being addressed")
+	pflag.Int("ambient.cooldown_minutes", cfg.Ambient.CooldownMinutes, "Minutes after the window during which the chance of replying fades out")
+	pflag.Int("ambient.max_replies", cfg.Ambient.MaxReplies, "Maximum replies to unaddressed messages per window (0 for no limit)")
+	pflag.Float64("ambient.reply_probability", cfg.Ambient.ReplyProbability, "Chance from 0 to 1 of considering an unaddressed message while engaged")
+	pflag.Int("ambient.max_silences", cfg.Ambient.MaxSilences, "Unanswered messages in a row before disengaging (0 to never)")
+	pflag.String("authorization.deny_response", "", "Response to users denied by the authorization policy (empty to ignore them)")
+	pflag.StringSlice("action_policy.confirm", cfg.ActionPolicy.Confirm, "Action types that wait for a user with the destructive permission to approve them")
+	pflag.Int("action_policy.confirm_timeout_minutes", cfg.ActionPolicy.ConfirmTimeoutMinutes, "Minutes an action confirmation can be answered")
+	pflag.Int("action_policy.audit_size", cfg.ActionPolicy.AuditSize, "Action decisions kept for the /actions command (0 to only log them)")
 	pflag.Int("rate_limit.requests_per_minute", cfg.RateLimit.RequestsPerMinute, "Maximum requests per minute")
+	pflag.Int("rate_limit.burst", cfg.RateLimit.Burst, "Maximum requests allowed at once (0 for a minute's worth)")
+	pflag.Int("rate_limit.guild_requests_per_minute", cfg.RateLimit.GuildRequestsPerMinute, "Maximum requests per minute from each guild (0 to disable)")
+	pflag.Int("rate_limit.channel_requests_per_minute", cfg.RateLimit.ChannelRequestsPerMinute, "Maximum requests per minute from each channel (0 to disable)")
+	pflag.Int("rate_limit.user_requests_per_minute", cfg.RateLimit.UserRequestsPerMinute, "Maximum requests per minute from each user (0 to disable)")
+	pflag.Int("queue.max_depth", cfg.Queue.MaxDepth, "Maximum messages waiting per conversation, including the one being answered (0 for no limit)")
+	pflag.Int("queue.workers", cfg.Queue.Workers, "Conversations answered at the same time")
+	pflag.Int("queue.debounce_ms", cfg.Queue.DebounceMs, "Milliseconds to wait for more messages in a channel before answering them together (0 to disable)")
 	pflag.String("logging.level", cfg.Logging.Level, "Logging level (debug, info, warn, error)")
 	pflag.String("logging.format", cfg.Logging.Format, "Logging format (json, text)")
 	pflag.String("logging.file", "", "Log file path (empty for stdout)")
@@ -168,6 +390,106 @@ func validateConfig(cfg *Config) error {
 		return errors.New("openwebui api key is required")
 	}
 
+	switch cfg.Discord.Attribution {
+	case "name", "prefix", "both":
+	default:
+		return fmt.Errorf("unknown attribution mode: %s", cfg.Discord.Attribution)
+	}
+
+	if cfg.RateLimit.RequestsPerMinute <= 0 {
+		return errors.New("rate limit requests per minute must be positive")
+	}
+
+	if cfg.Queue.Workers <= 0 {
+		return errors.New("queue workers must be positive")
+	}
+
+	if cfg.Queue.DebounceMs < 0 {
+		return errors.New("queue debounce must not be negative")
+	}
+
+	switch cfg.Discord.ThreadArchiveMinutes {
+	case 60, 1440, 4320, 10080:
+	default:
+		return fmt.Errorf("invalid thread archive duration: %d minutes", cfg.Discord.ThreadArchiveMinutes)
+	}
+
+	if !validActionMode(cfg.OpenWebUI.ActionMode) {
+		return fmt.Errorf("unknown action mode: %s", cfg.OpenWebUI.ActionMode)
+	}
+
+	for _, profiles := range []map[string]Profile{cfg.Profiles.Guilds, cfg.Profiles.Categories, cfg.Profiles.Channels} {
+		for id, profile := range profiles {
+			if profile.ActionMode != "" && !validActionMode(profile.ActionMode) {
+				return fmt.Errorf("unknown action mode for profile %s: %s", id, profile.ActionMode)
+			}
+		}
+	}
+
+	for actionType, perMinute := range cfg.ActionPolicy.RatePerMinute {
+		if perMinute < 0 {
+			return fmt.Errorf("action rate for %s must not be negative", actionType)
+		}
+	}
+
+	if len(cfg.ActionPolicy.Confirm) > 0 && cfg.ActionPolicy.ConfirmTimeoutMinutes <= 0 {
+		return errors.New("action confirmation timeout must be positive")
+	}
+
+	// Without an allow list anyone could approve a confirmation, including whoever asked for it
+	if len(cfg.ActionPolicy.Confirm) > 0 {
+		if !cfg.Authorization.Destructive.hasAllowList() {
+			return errors.New("action confirmations need an authorization.destructive allow list")
+		}
+		for guildID, rules := range cfg.Authorization.Guilds {
+			if rules.Destructive != nil && !rules.Destructive.hasAllowList() {
+				return fmt.Errorf("action confirmations need a destructive allow list for guild %s", guildID)
+			}
+		}
+	}
+
+	if cfg.ActionPolicy.AuditSize < 0 {
+		return errors.New("action audit size must not be negative")
+	}
+
+	switch cfg.DirectMessages.Access {
+	case "allowlist", "guild_members":
+	default:
+		return fmt.Errorf("unknown direct message access mode: %s", cfg.DirectMessages.Access)
+	}
+
+	switch cfg.Ambient.Gate {
+	case "off", "heuristic", "classifier":
+	default:
+		return fmt.Errorf("unknown ambient gate: %s", cfg.Ambient.Gate)
+	}
+
+	if cfg.Ambient.Threshold < 0 || cfg.Ambient.Threshold > 1 {
+		return errors.New("ambient threshold must be between 0 and 1")
+	}
+
+	if cfg.Ambient.ReplyProbability < 0 || cfg.Ambient.ReplyProbability > 1 {
+		return errors.New("ambient reply probability must be between 0 and 1")
+	}
+
+	if cfg.Ambient.WindowMinutes <= 0 {
+		return errors.New("ambient window must be positive")
+	}
+
+	if cfg.Ambient.CooldownMinutes < 0 || cfg.Ambient.MaxReplies < 0 || cfg.Ambient.MaxSilences < 0 {
+		return errors.New("ambient cooldown, max replies and max silences must not be negative")
+	}
+
+	switch cfg.Context.Store {
+	case "memory":
+	case "bolt":
+		if cfg.Context.StorePath == "" {
+			return errors.New("context store path is required for the bolt store")
+		}
+	default:
+		return fmt.Errorf("unknown context store: %s", cfg.Context.Store)
+	}
+
 	// Validate logging file path if specified
 	if cfg.Logging.File != "" {
 		dir := filepath.Dir(cfg.Logging.File)
@@ -181,6 +503,11 @@ func validateConfig(cfg *Config) error {
 	return nil
 }
 
+// validActionMode reports whether mode is a known action mode
+func validActionMode(mode string) bool {
+	return mode == "tools" || mode == "markup"
+}
+
 // SaveExample saves an example configuration file
 func SaveExample(path string) error {
 	cfg := DefaultConfig()
@@ -205,10 +532,22 @@ func SaveExample(path string) error {
 			"timeout":       cfg.OpenWebUI.Timeout,
 			"tool_ids":      cfg.OpenWebUI.ToolIDs,
 			"system_prompt": cfg.OpenWebUI.SystemPrompt, // Add system prompt here
+			"stream":        cfg.OpenWebUI.Stream,
+			"action_mode":   cfg.OpenWebUI.ActionMode,
+
+			"max_prompt_tokens":       cfg.OpenWebUI.MaxPromptTokens,
+			"model_max_prompt_tokens": cfg.OpenWebUI.ModelMaxPromptTokens,
 		},
-		"context":    cfg.Context,
-		"rate_limit": cfg.RateLimit,
-		"logging":    cfg.Logging,
+		"context":         cfg.Context,
+		"attachments":     cfg.Attachments,
+		"direct_messages": cfg.DirectMessages,
+		"ambient":         cfg.Ambient,
+		"authorization":   cfg.Authorization,
+		"action_policy":   cfg.ActionPolicy,
+		"profiles":        cfg.Profiles,
+		"rate_limit":      cfg.RateLimit,
+		"queue":           cfg.Queue,
+		"logging":         cfg.Logging,
 	})
 
 	if err != nil {
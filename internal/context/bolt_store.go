@@ -0,0 +1,125 @@
+package context
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// contextsBucket is the bbolt bucket that holds channel contexts keyed by channel ID
+var contextsBucket = []byte("contexts")
+
+// BoltStore persists channel contexts to an embedded bbolt database file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates a bbolt database at the given path
+func NewBoltStore(path string) (*BoltStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("bolt store requires a path")
+	}
+
+	// Create directory if it doesn't exist
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("could not create context store directory: %w", err)
+		}
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening context store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(contextsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating context bucket: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Get returns the context for a channel
+func (s *BoltStore) Get(channelID string) (*ChannelContext, error) {
+	var ctx *ChannelContext
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(contextsBucket).Get([]byte(channelID))
+		if data == nil {
+			return nil
+		}
+
+		ctx = &ChannelContext{}
+		return json.Unmarshal(data, ctx)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading context for channel %s: %w", channelID, err)
+	}
+
+	return ctx, nil
+}
+
+// Put creates or replaces the context for a channel
+func (s *BoltStore) Put(ctx *ChannelContext) error {
+	data, err := json.Marshal(ctx)
+	if err != nil {
+		return fmt.Errorf("error marshaling context: %w", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(contextsBucket).Put([]byte(ctx.ChannelID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("error writing context for channel %s: %w", ctx.ChannelID, err)
+	}
+
+	return nil
+}
+
+// Delete removes the context for a channel
+func (s *BoltStore) Delete(channelID string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(contextsBucket).Delete([]byte(channelID))
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting context for channel %s: %w", channelID, err)
+	}
+
+	return nil
+}
+
+// List returns every stored context
+func (s *BoltStore) List() ([]*ChannelContext, error) {
+	var contexts []*ChannelContext
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(contextsBucket).ForEach(func(k, v []byte) error {
+			ctx := &ChannelContext{}
+			if err := json.Unmarshal(v, ctx); err != nil {
+				return fmt.Errorf("error parsing context for channel %s: %w", string(k), err)
+			}
+			contexts = append(contexts, ctx)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing contexts: %w", err)
+	}
+
+	return contexts, nil
+}
+
+// Close closes the underlying database
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,184 @@
+package context
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"go.uber.org/zap"
+)
+
+// EngagementState describes how a channel's conversation is being followed without mentions
+type EngagementState string
+
+const (
+	// EngagementIdle means the bot only answers messages addressed to it
+	EngagementIdle EngagementState = "idle"
+	// EngagementEngaged means the bot may join in without being addressed
+	EngagementEngaged EngagementState = "engaged"
+	// EngagementCoolingDown means the window has passed and the chance of joining in is fading
+	EngagementCoolingDown EngagementState = "cooling_down"
+)
+
+// Engagement tracks whether the bot is following a channel's conversation
+type Engagement struct {
+	State EngagementState `json:"state"`
+	// EngagedAt is when the bot was last addressed, which starts the window
+	EngagedAt time.Time `json:"engaged_at"`
+	// UnsolicitedReplies counts replies to messages that didn't address the bot in this window
+	UnsolicitedReplies int `json:"unsolicited_replies"`
+	// ConsecutiveSilences counts unaddressed messages in a row the bot chose not to answer
+	ConsecutiveSilences int `json:"consecutive_silences"`
+	// Reason explains the last time the bot disengaged
+	Reason string `json:"reason,omitempty"`
+}
+
+// EngagementPolicy controls how long and how often the bot joins in after being addressed
+type EngagementPolicy struct {
+	// Window is how long after being addressed the bot stays engaged
+	Window time.Duration
+	// Cooldown is how long after the window the chance of replying fades to nothing
+	Cooldown time.Duration
+	// MaxUnsolicitedReplies caps replies to unaddressed messages per window, 0 for no cap
+	MaxUnsolicitedReplies int
+	// ReplyProbability is the chance of considering an unaddressed message while engaged
+	ReplyProbability float64
+	// MaxSilences disengages after this many unanswered messages in a row, 0 to never
+	MaxSilences int
+}
+
+// ReplyChance returns the probability of considering an unaddressed message at a time,
+// fading during the cooldown
+func (p EngagementPolicy) ReplyChance(engagement Engagement, now time.Time) float64 {
+	switch p.state(engagement, now) {
+	case EngagementEngaged:
+		return p.ReplyProbability
+	case EngagementCoolingDown:
+		remaining := engagement.EngagedAt.Add(p.Window + p.Cooldown).Sub(now)
+		return p.ReplyProbability * float64(remaining) / float64(p.Cooldown)
+	}
+
+	return 0
+}
+
+// state works out the engagement state at a time, moving through the window and cooldown
+func (p EngagementPolicy) state(engagement Engagement, now time.Time) EngagementState {
+	if engagement.State == "" || engagement.State == EngagementIdle {
+		return EngagementIdle
+	}
+
+	elapsed := now.Sub(engagement.EngagedAt)
+	switch {
+	case elapsed < p.Window:
+		return EngagementEngaged
+	case elapsed < p.Window+p.Cooldown:
+		return EngagementCoolingDown
+	}
+
+	return EngagementIdle
+}
+
+// Engage marks the bot as addressed in a channel, starting a new engagement window
+func (m *Manager) Engage(channelID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ctx := m.loadOrCreate(channelID)
+	ctx.Engagement = Engagement{
+		State:     EngagementEngaged,
+		EngagedAt: time.Now(),
+	}
+	m.save(ctx)
+}
+
+// GetEngagement returns a channel's engagement with its state brought up to date
+func (m *Manager) GetEngagement(channelID string, policy EngagementPolicy) Engagement {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	ctx := m.load(channelID)
+	if ctx == nil {
+		return Engagement{State: EngagementIdle}
+	}
+
+	engagement := ctx.Engagement
+	engagement.State = policy.state(engagement, time.Now())
+	return engagement
+}
+
+// AllowUnsolicited decides whether an unaddressed message in a channel may be considered
+// for a reply, returning the reason when it may not
+func (m *Manager) AllowUnsolicited(channelID string, policy EngagementPolicy) (bool, string) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	ctx := m.load(channelID)
+	if ctx == nil {
+		return false, "idle"
+	}
+
+	now := time.Now()
+	engagement := ctx.Engagement
+	if policy.state(engagement, now) == EngagementIdle {
+		return false, "idle"
+	}
+
+	if policy.MaxUnsolicitedReplies > 0 && engagement.UnsolicitedReplies >= policy.MaxUnsolicitedReplies {
+		return false, "reply limit reached"
+	}
+
+	if rand.Float64() >= policy.ReplyChance(engagement, now) {
+		return false, "not chosen"
+	}
+
+	return true, ""
+}
+
+// RecordUnsolicited records whether the bot replied to an unaddressed message, disengaging
+// once it has stayed silent too many times in a row
+func (m *Manager) RecordUnsolicited(channelID string, policy EngagementPolicy, replied bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ctx := m.load(channelID)
+	if ctx == nil {
+		return
+	}
+
+	engagement := &ctx.Engagement
+	if replied {
+		engagement.UnsolicitedReplies++
+		engagement.ConsecutiveSilences = 0
+	} else {
+		engagement.ConsecutiveSilences++
+	}
+
+	if policy.MaxSilences > 0 && engagement.ConsecutiveSilences >= policy.MaxSilences {
+		engagement.State = EngagementIdle
+		engagement.Reason = "stayed silent too many times"
+
+		logger.Debug("Disengaged from channel",
+			zap.String("channel_id", channelID),
+			zap.Int("silences", engagement.ConsecutiveSilences),
+		)
+	}
+
+	m.save(ctx)
+}
+
+// loadOrCreate returns the stored context for a channel, creating an empty one if there is
+// none. Callers must hold the mutex.
+func (m *Manager) loadOrCreate(channelID string) *ChannelContext {
+	ctx := m.load(channelID)
+	if ctx == nil {
+		now := time.Now()
+		ctx = &ChannelContext{
+			ChannelID:  channelID,
+			Messages:   make([]Message, 0),
+			StartedAt:  now,
+			LastActive: now,
+		}
+	}
+
+	return ctx
+}
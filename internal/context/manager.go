@@ -2,6 +2,7 @@ package context
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,10 +12,19 @@ import (
 
 // Message represents a single message in a conversation
 type Message struct {
-	Role      string    `json:"role"`
-	Content   string    `json:"content"`
-	Name      string    `json:"name"`
-	Timestamp time.Time `json:"timestamp"`
+	Role        string       `json:"role"`
+	Content     string       `json:"content"`
+	Name        string       `json:"name"`
+	Timestamp   time.Time    `json:"timestamp"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment references a file that was attached to a message
+type Attachment struct {
+	Filename    string `json:"filename"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
 }
 
 // ChannelContext represents the conversation context for a specific channel
@@ -22,20 +32,48 @@ type ChannelContext struct {
 	ChannelID  string    `json:"channel_id"`
 	Messages   []Message `json:"messages"`
 	LastActive time.Time `json:"last_active"`
+	StartedAt  time.Time `json:"started_at"`
+	Summary    string    `json:"summary,omitempty"`
+
+	// Engagement tracks whether the bot is following the conversation without mentions
+	Engagement Engagement `json:"engagement"`
+}
+
+// directMessagePrefix namespaces direct message contexts so they never share a key with a channel
+const directMessagePrefix = "dm:"
+
+// DirectMessageKey returns the context key for a user's private direct message conversation
+func DirectMessageKey(userID string) string {
+	return directMessagePrefix + userID
+}
+
+// IsDirectMessageKey reports whether a context key belongs to a direct message conversation
+func IsDirectMessageKey(key string) bool {
+	return strings.HasPrefix(key, directMessagePrefix)
 }
 
 // Manager handles conversation contexts for multiple channels
 type Manager struct {
-	contexts      map[string]*ChannelContext
+	store         Store
 	maxAgeMinutes int
 	mutex         sync.RWMutex
+	done          chan struct{}
+	closeOnce     sync.Once
+	summarizer    Summarizer
+	summaryJobs   chan summaryJob
 }
 
-// NewManager creates a new context manager
+// NewManager creates a new context manager that keeps contexts in memory
 func NewManager(maxAgeMinutes int) *Manager {
+	return NewManagerWithStore(maxAgeMinutes, NewMemoryStore())
+}
+
+// NewManagerWithStore creates a new context manager backed by the given store
+func NewManagerWithStore(maxAgeMinutes int, store Store) *Manager {
 	manager := &Manager{
-		contexts:      make(map[string]*ChannelContext),
+		store:         store,
 		maxAgeMinutes: maxAgeMinutes,
+		done:          make(chan struct{}),
 	}
 
 	// Start a goroutine to periodically clean up old contexts
@@ -44,33 +82,65 @@ func NewManager(maxAgeMinutes int) *Manager {
 	return manager
 }
 
+// Close stops the cleanup loop and closes the underlying store
+func (m *Manager) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.done)
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		err = m.store.Close()
+	})
+
+	return err
+}
+
+// load returns the stored context for a channel, logging and treating read errors as empty
+func (m *Manager) load(channelID string) *ChannelContext {
+	ctx, err := m.store.Get(channelID)
+	if err != nil {
+		logger.Error("Failed to load channel context", zap.String("channel_id", channelID), zap.Error(err))
+		return nil
+	}
+
+	return ctx
+}
+
+// save writes a channel context to the store, logging any error
+func (m *Manager) save(ctx *ChannelContext) {
+	if err := m.store.Put(ctx); err != nil {
+		logger.Error("Failed to save channel context", zap.String("channel_id", ctx.ChannelID), zap.Error(err))
+	}
+}
+
 // AddMessage adds a message to a channel's context
 func (m *Manager) AddMessage(channelID, role, content, username string) {
+	m.AddMessageWithAttachments(channelID, role, content, username, nil)
+}
+
+// AddMessageWithAttachments adds a message and references to its attachments to a channel's context
+func (m *Manager) AddMessageWithAttachments(channelID, role, content, username string, attachments []Attachment) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	// Get or create channel context
-	ctx, exists := m.contexts[channelID]
-	if !exists {
-		ctx = &ChannelContext{
-			ChannelID: channelID,
-			Messages:  make([]Message, 0),
-		}
-		m.contexts[channelID] = ctx
-	}
+	ctx := m.loadOrCreate(channelID)
 
 	// Add message
 	message := Message{
-		Role:      role,
-		Content:   content,
-		Name:      username,
-		Timestamp: time.Now(),
+		Role:        role,
+		Content:     content,
+		Name:        username,
+		Timestamp:   time.Now(),
+		Attachments: attachments,
 	}
 	ctx.Messages = append(ctx.Messages, message)
 	ctx.LastActive = time.Now()
 
 	// Prune old messages
-	m.pruneChannelContext(ctx)
+	m.summarizePruned(ctx, m.pruneChannelContext(ctx))
+	m.save(ctx)
 
 	logger.Debug("Added message to context",
 		zap.String("channel_id", channelID),
@@ -84,16 +154,13 @@ func (m *Manager) GetMessages(channelID string) []Message {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	ctx, exists := m.contexts[channelID]
-	if !exists {
+	ctx := m.load(channelID)
+	if ctx == nil {
 		return []Message{}
 	}
 
-	// Return a copy of the messages to prevent modification
-	messages := make([]Message, len(ctx.Messages))
-	copy(messages, ctx.Messages)
-
-	return messages
+	// Stores return copies, so the messages are safe to hand out
+	return ctx.Messages
 }
 
 // ClearChannel clears the context for a specific channel
@@ -101,18 +168,21 @@ func (m *Manager) ClearChannel(channelID string) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	delete(m.contexts, channelID)
+	if err := m.store.Delete(channelID); err != nil {
+		logger.Error("Failed to clear channel context", zap.String("channel_id", channelID), zap.Error(err))
+		return
+	}
 	logger.Debug("Cleared channel context", zap.String("channel_id", channelID))
 }
 
-// pruneChannelContext removes messages older than the max age
-func (m *Manager) pruneChannelContext(ctx *ChannelContext) {
+// pruneChannelContext removes messages older than the max age and returns the removed messages
+func (m *Manager) pruneChannelContext(ctx *ChannelContext) []Message {
 	if len(ctx.Messages) == 0 {
-		return
+		return nil
 	}
 
 	cutoffTime := time.Now().Add(-time.Duration(m.maxAgeMinutes) * time.Minute)
-	firstValidIndex := 0
+	firstValidIndex := len(ctx.Messages)
 
 	// Find the first message that's within the time window
 	for i, msg := range ctx.Messages {
@@ -122,21 +192,20 @@ func (m *Manager) pruneChannelContext(ctx *ChannelContext) {
 		}
 	}
 
-	// If all messages are too old, clear them
-	if firstValidIndex >= len(ctx.Messages) {
-		ctx.Messages = []Message{}
-		return
+	if firstValidIndex == 0 {
+		return nil
 	}
 
-	// If some messages are too old, remove them
-	if firstValidIndex > 0 {
-		ctx.Messages = ctx.Messages[firstValidIndex:]
-		logger.Debug("Pruned old messages from context",
-			zap.String("channel_id", ctx.ChannelID),
-			zap.Int("removed", firstValidIndex),
-			zap.Int("remaining", len(ctx.Messages)),
-		)
-	}
+	// Remove the messages that are too old, which may be all of them
+	removed := ctx.Messages[:firstValidIndex]
+	ctx.Messages = ctx.Messages[firstValidIndex:]
+	logger.Debug("Pruned old messages from context",
+		zap.String("channel_id", ctx.ChannelID),
+		zap.Int("removed", firstValidIndex),
+		zap.Int("remaining", len(ctx.Messages)),
+	)
+
+	return removed
 }
 
 // cleanupLoop periodically cleans up inactive contexts
@@ -144,12 +213,18 @@ func (m *Manager) cleanupLoop() {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		m.cleanupInactiveContexts()
+	for {
+		select {
+		case <-ticker.C:
+			m.cleanupInactiveContexts()
+		case <-m.done:
+			return
+		}
 	}
 }
 
-// cleanupInactiveContexts removes contexts that have been inactive for too long
+// cleanupInactiveContexts removes contexts that have been inactive for too long and
+// prunes the rest
 func (m *Manager) cleanupInactiveContexts() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -157,13 +232,26 @@ func (m *Manager) cleanupInactiveContexts() {
 	cutoffTime := time.Now().Add(-time.Duration(m.maxAgeMinutes*2) * time.Minute)
 	var removedCount int
 
-	for channelID, ctx := range m.contexts {
-		if ctx.LastActive.Before(cutoffTime) {
-			delete(m.contexts, channelID)
+	contexts, err := m.store.List()
+	if err != nil {
+		logger.Error("Failed to list contexts for cleanup", zap.Error(err))
+		return
+	}
+
+	for _, ctx := range contexts {
+		// With a summarizer, inactive contexts keep their summary and only go once there's
+		// nothing left to remember
+		inactive := ctx.LastActive.Before(cutoffTime)
+		if inactive && (m.summarizer == nil || (len(ctx.Messages) == 0 && ctx.Summary == "")) {
+			if err := m.store.Delete(ctx.ChannelID); err != nil {
+				logger.Error("Failed to remove inactive context", zap.String("channel_id", ctx.ChannelID), zap.Error(err))
+				continue
+			}
 			removedCount++
-		} else {
-			// Also prune old messages from active contexts
-			m.pruneChannelContext(ctx)
+		} else if removed := m.pruneChannelContext(ctx); len(removed) > 0 {
+			// Prune old messages from the contexts that are kept, folding them into the summary
+			m.summarizePruned(ctx, removed)
+			m.save(ctx)
 		}
 	}
 
@@ -177,8 +265,8 @@ func (m *Manager) GetContextSize(channelID string) int {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	ctx, exists := m.contexts[channelID]
-	if !exists {
+	ctx := m.load(channelID)
+	if ctx == nil {
 		return 0
 	}
 
@@ -213,8 +301,8 @@ func (m *Manager) WasRecentlyMentionedOrCommanded(channelID string, windowMinute
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	ctx, exists := m.contexts[channelID]
-	if !exists {
+	ctx := m.load(channelID)
+	if ctx == nil {
 		return false
 	}
 
@@ -0,0 +1,94 @@
+package context
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/justmiles/openwebui-discord/internal/config"
+	"github.com/justmiles/openwebui-discord/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.Init(config.DefaultConfig()); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestPruneChannelContext(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-time.Hour)
+	recent := now.Add(-time.Minute)
+
+	tests := []struct {
+		name      string
+		times     []time.Time
+		removed   []string
+		remaining []string
+	}{
+		{
+			name:      "none old",
+			times:     []time.Time{recent, recent},
+			remaining: []string{"0", "1"},
+		},
+		{
+			name:      "some old",
+			times:     []time.Time{old, old, recent},
+			removed:   []string{"0", "1"},
+			remaining: []string{"2"},
+		},
+		{
+			name:    "all old",
+			times:   []time.Time{old, old},
+			removed: []string{"0", "1"},
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Manager{maxAgeMinutes: 30}
+			ctx := &ChannelContext{ChannelID: "channel"}
+			for i, timestamp := range tt.times {
+				ctx.Messages = append(ctx.Messages, Message{Content: string(rune('0' + i)), Timestamp: timestamp})
+			}
+
+			removed := m.pruneChannelContext(ctx)
+
+			if got := contents(removed); !equalStrings(got, tt.removed) {
+				t.Errorf("removed = %v, want %v", got, tt.removed)
+			}
+			if got := contents(ctx.Messages); !equalStrings(got, tt.remaining) {
+				t.Errorf("remaining = %v, want %v", got, tt.remaining)
+			}
+		})
+	}
+}
+
+// contents returns the content of each message
+func contents(messages []Message) []string {
+	var result []string
+	for _, msg := range messages {
+		result = append(result, msg.Content)
+	}
+
+	return result
+}
+
+// equalStrings compares two slices, treating nil and empty as equal
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,112 @@
+package context
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Store persists channel contexts for the Manager
+type Store interface {
+	// Get returns the context for a channel, or nil if none is stored
+	Get(channelID string) (*ChannelContext, error)
+
+	// Put creates or replaces the context for a channel
+	Put(ctx *ChannelContext) error
+
+	// Delete removes the context for a channel
+	Delete(channelID string) error
+
+	// List returns every stored context
+	List() ([]*ChannelContext, error)
+
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// Store types supported by NewStore
+const (
+	StoreMemory = "memory"
+	StoreBolt   = "bolt"
+)
+
+// NewStore creates a store of the given type. The path is only used by file-based stores.
+func NewStore(storeType, path string) (Store, error) {
+	switch storeType {
+	case "", StoreMemory:
+		return NewMemoryStore(), nil
+	case StoreBolt:
+		return NewBoltStore(path)
+	default:
+		return nil, fmt.Errorf("unknown context store type: %s", storeType)
+	}
+}
+
+// MemoryStore keeps channel contexts in memory. Contexts are lost when the process exits.
+type MemoryStore struct {
+	contexts map[string]*ChannelContext
+	mutex    sync.RWMutex
+}
+
+// NewMemoryStore creates a new in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		contexts: make(map[string]*ChannelContext),
+	}
+}
+
+// Get returns a copy of the context for a channel
+func (s *MemoryStore) Get(channelID string) (*ChannelContext, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ctx, exists := s.contexts[channelID]
+	if !exists {
+		return nil, nil
+	}
+
+	return ctx.clone(), nil
+}
+
+// Put stores a copy of the context
+func (s *MemoryStore) Put(ctx *ChannelContext) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.contexts[ctx.ChannelID] = ctx.clone()
+	return nil
+}
+
+// Delete removes the context for a channel
+func (s *MemoryStore) Delete(channelID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.contexts, channelID)
+	return nil
+}
+
+// List returns copies of every stored context
+func (s *MemoryStore) List() ([]*ChannelContext, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	contexts := make([]*ChannelContext, 0, len(s.contexts))
+	for _, ctx := range s.contexts {
+		contexts = append(contexts, ctx.clone())
+	}
+
+	return contexts, nil
+}
+
+// Close is a no-op for the in-memory store
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// clone returns a copy of the context that shares no mutable state with the original
+func (c *ChannelContext) clone() *ChannelContext {
+	clone := *c
+	clone.Messages = make([]Message, len(c.Messages))
+	copy(clone.Messages, c.Messages)
+	return &clone
+}
@@ -0,0 +1,213 @@
+package context
+
+import (
+	stdcontext "context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"github.com/justmiles/openwebui-discord/internal/openwebui"
+	"go.uber.org/zap"
+)
+
+// summaryQueueSize bounds how many pruned batches can wait to be summarized
+const summaryQueueSize = 64
+
+// Summarizer folds messages that are about to be discarded into a running summary
+type Summarizer interface {
+	Summarize(summary string, messages []Message) (string, error)
+}
+
+// summaryJob is a batch of pruned messages waiting to be folded into a channel's summary
+type summaryJob struct {
+	channelID string
+	startedAt time.Time
+	messages  []Message
+}
+
+// SetSummarizer enables rolling summarization of pruned messages. Summaries are generated
+// in the background so pruning never waits on the summarizer.
+func (m *Manager) SetSummarizer(summarizer Summarizer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.summarizer == nil {
+		m.summaryJobs = make(chan summaryJob, summaryQueueSize)
+		go m.summaryLoop()
+	}
+	m.summarizer = summarizer
+}
+
+// GetSummary returns the running summary of a channel's aged-out history
+func (m *Manager) GetSummary(channelID string) string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	ctx := m.load(channelID)
+	if ctx == nil {
+		return ""
+	}
+
+	return ctx.Summary
+}
+
+// summarizePruned queues pruned messages to be folded into the channel's summary.
+// Callers must hold the mutex.
+func (m *Manager) summarizePruned(ctx *ChannelContext, removed []Message) {
+	if m.summarizer == nil || len(removed) == 0 {
+		return
+	}
+
+	job := summaryJob{
+		channelID: ctx.ChannelID,
+		startedAt: ctx.StartedAt,
+		messages:  append([]Message(nil), removed...),
+	}
+
+	select {
+	case m.summaryJobs <- job:
+	default:
+		logger.Warn("Summary queue is full, discarding pruned messages",
+			zap.String("channel_id", ctx.ChannelID),
+			zap.Int("messages", len(removed)),
+		)
+	}
+}
+
+// summaryLoop processes queued summary jobs one at a time so a channel's summary is
+// always built from the previous one
+func (m *Manager) summaryLoop() {
+	for {
+		select {
+		case job := <-m.summaryJobs:
+			m.applySummary(job)
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// applySummary generates a new summary for a job and stores it with the channel context
+func (m *Manager) applySummary(job summaryJob) {
+	m.mutex.RLock()
+	summarizer := m.summarizer
+	ctx := m.load(job.channelID)
+	m.mutex.RUnlock()
+
+	// Skip channels that were cleared since the messages were pruned
+	if ctx == nil || !ctx.StartedAt.Equal(job.startedAt) {
+		return
+	}
+
+	summary, err := summarizer.Summarize(ctx.Summary, job.messages)
+	if err != nil {
+		logger.Warn("Failed to summarize pruned messages",
+			zap.String("channel_id", job.channelID),
+			zap.Int("messages", len(job.messages)),
+			zap.Error(err),
+		)
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Reload in case the context changed while the summary was generated
+	ctx = m.load(job.channelID)
+	if ctx == nil || !ctx.StartedAt.Equal(job.startedAt) {
+		return
+	}
+
+	ctx.Summary = summary
+	m.save(ctx)
+
+	logger.Debug("Updated channel summary",
+		zap.String("channel_id", job.channelID),
+		zap.Int("summarized", len(job.messages)),
+		zap.Int("summary_length", len(summary)),
+	)
+}
+
+// OpenWebUISummarizer summarizes conversation history with an OpenWebUI model
+type OpenWebUISummarizer struct {
+	client   *openwebui.Client
+	maxChars int
+	timeout  time.Duration
+}
+
+// NewOpenWebUISummarizer creates a summarizer that keeps summaries under maxChars characters.
+// An empty model uses the client's model.
+func NewOpenWebUISummarizer(client *openwebui.Client, model string, maxChars int) *OpenWebUISummarizer {
+	if model != "" {
+		client = client.WithModel(model)
+	}
+
+	return &OpenWebUISummarizer{
+		client:   client,
+		maxChars: maxChars,
+		timeout:  time.Minute,
+	}
+}
+
+// Summarize asks the model to merge messages into the existing summary
+func (s *OpenWebUISummarizer) Summarize(summary string, messages []Message) (string, error) {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		speaker := msg.Role
+		if msg.Name != "" {
+			speaker = msg.Name
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", speaker, msg.Content)
+	}
+
+	previous := summary
+	if previous == "" {
+		previous = "(none)"
+	}
+
+	request := []openwebui.Message{
+		{
+			Role: "system",
+			Content: fmt.Sprintf("You maintain a running summary of a Discord conversation. "+
+				"Merge the previous summary with the new messages into a single summary of at most %d characters. "+
+				"Keep who said what, decisions, facts, open questions and anything the assistant promised to do. "+
+				"Reply with the summary only.", s.maxChars),
+		},
+		{
+			Role:    "user",
+			Content: fmt.Sprintf("Previous summary:\n%s\n\nNew messages:\n%s", previous, transcript.String()),
+		},
+	}
+
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), s.timeout)
+	defer cancel()
+
+	result, err := s.client.GetCompletion(ctx, request)
+	if err != nil {
+		return "", fmt.Errorf("error generating summary: %w", err)
+	}
+
+	result = strings.TrimSpace(result)
+
+	// Enforce the cap in case the model ignored it
+	if s.maxChars > 0 && len(result) > s.maxChars {
+		result = truncateRunes(result, s.maxChars)
+	}
+
+	return result, nil
+}
+
+// truncateRunes cuts text to at most limit bytes without splitting a character
+func truncateRunes(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+
+	for limit > 0 && !utf8.RuneStart(text[limit]) {
+		limit--
+	}
+
+	return text[:limit]
+}
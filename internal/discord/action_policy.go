@@ -0,0 +1,498 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"github.com/justmiles/openwebui-discord/internal/ratelimit"
+	"go.uber.org/zap"
+)
+
+// defaultConfirmTimeout is how long a confirmation can be answered when no timeout is configured
+const defaultConfirmTimeout = 10 * time.Minute
+
+// confirmButtonPrefix starts the custom ID of the buttons that approve or deny an action
+const confirmButtonPrefix = "action:"
+
+// ActionPolicyOptions limits the actions the model can take beyond each profile's allow
+// and deny lists
+type ActionPolicyOptions struct {
+	// RatePerMinute caps how often each action type runs in a channel, keyed by action type
+	RatePerMinute map[string]int
+	// Confirm lists the action types that wait for a user with the destructive permission
+	// to approve them. Actions that rewrite the reply can't wait and aren't affected.
+	Confirm []string
+	// ConfirmTimeout is how long a confirmation can be answered
+	ConfirmTimeout time.Duration
+	// AuditSize is how many audit records are kept in memory
+	AuditSize int
+}
+
+// ActionAuditRecord records a policy decision about an action the model asked for
+type ActionAuditRecord struct {
+	Time       time.Time
+	Type       ActionType
+	Parameters string
+	GuildID    string
+	ChannelID  string
+	// UserID is who the action was asked for in response to
+	UserID string
+	// Decision is allowed, denied, pending, approved, rejected or expired
+	Decision string
+	Reason   string
+	// DecidedBy is who approved or rejected a confirmation
+	DecidedBy string
+}
+
+// actionRequest identifies who the model's actions respond to
+type actionRequest struct {
+	GuildID string
+	UserID  string
+	Member  *discordgo.Member
+	// Profile decides which actions are enabled, allowing every action when nil
+	Profile *effectiveProfile
+}
+
+// actionOutcome is what happened to an action the model asked for
+type actionOutcome int
+
+const (
+	actionAllowed actionOutcome = iota
+	actionDenied
+	actionPending
+	// actionDeferred actions are decided once the reply has been sent
+	actionDeferred
+)
+
+// actionDecision is the outcome of an action and why
+type actionDecision struct {
+	Outcome actionOutcome
+	Reason  string
+}
+
+// toolResult describes a decision to the model as the result of its tool call
+func (d actionDecision) toolResult() string {
+	switch d.Outcome {
+	case actionAllowed:
+		return "done"
+	case actionPending:
+		return "waiting for a moderator to approve it"
+	case actionDeferred:
+		return "ok, this will be applied once your reply is ready"
+	}
+
+	return "error: " + d.Reason
+}
+
+// pendingAction is an action waiting for confirmation
+type pendingAction struct {
+	action    Action
+	actx      ActionContext
+	request   actionRequest
+	messageID string
+	timer     *time.Timer
+}
+
+// actionPolicy holds the rate limits, confirmations and audit log for actions
+type actionPolicy struct {
+	options  ActionPolicyOptions
+	confirm  map[ActionType]bool
+	limiters map[string]*ratelimit.Limiter
+	pending  map[string]*pendingAction
+	audit    []ActionAuditRecord
+	nextID   int
+	mutex    sync.Mutex
+}
+
+// newActionPolicy creates an action policy from its options
+func newActionPolicy(options ActionPolicyOptions) *actionPolicy {
+	confirm := make(map[ActionType]bool, len(options.Confirm))
+	for _, actionType := range options.Confirm {
+		confirm[ActionType(strings.ToLower(actionType))] = true
+	}
+
+	return &actionPolicy{
+		options:  options,
+		confirm:  confirm,
+		limiters: make(map[string]*ratelimit.Limiter),
+		pending:  make(map[string]*pendingAction),
+	}
+}
+
+// allowRate consumes one use of an action type's rate cap in a channel
+func (p *actionPolicy) allowRate(channelID string, actionType ActionType) bool {
+	perMinute := p.options.RatePerMinute[string(actionType)]
+	if perMinute <= 0 {
+		return true
+	}
+
+	p.mutex.Lock()
+	key := channelID + ":" + string(actionType)
+	limiter, exists := p.limiters[key]
+	if !exists {
+		limiter = ratelimit.NewLimiter(perMinute)
+		p.limiters[key] = limiter
+	}
+	p.mutex.Unlock()
+
+	return limiter.Allow()
+}
+
+// record logs a decision and keeps it in the audit log
+func (p *actionPolicy) record(record ActionAuditRecord) {
+	record.Time = time.Now()
+
+	logger.Info("Action audit",
+		zap.String("type", string(record.Type)),
+		zap.String("params", record.Parameters),
+		zap.String("guild_id", record.GuildID),
+		zap.String("channel_id", record.ChannelID),
+		zap.String("user_id", record.UserID),
+		zap.String("decision", record.Decision),
+		zap.String("reason", record.Reason),
+		zap.String("decided_by", record.DecidedBy),
+	)
+
+	if p.options.AuditSize <= 0 {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.audit = append(p.audit, record)
+	if overflow := len(p.audit) - p.options.AuditSize; overflow > 0 {
+		p.audit = append([]ActionAuditRecord(nil), p.audit[overflow:]...)
+	}
+}
+
+// records returns the audit records for a channel, or every channel when channelID is empty
+func (p *actionPolicy) records(channelID string) []ActionAuditRecord {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var records []ActionAuditRecord
+	for _, record := range p.audit {
+		if channelID == "" || record.ChannelID == channelID {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// auditRecord starts an audit record for an action
+func auditRecord(req actionRequest, actx *ActionContext, action Action, decision, reason string) ActionAuditRecord {
+	return ActionAuditRecord{
+		Type:       action.Type,
+		Parameters: action.Parameters,
+		GuildID:    req.GuildID,
+		ChannelID:  actx.ChannelID,
+		UserID:     req.UserID,
+		Decision:   decision,
+		Reason:     reason,
+	}
+}
+
+// applyActions runs the actions for a phase that the policy allows, asking for
+// confirmation where it is required, and returns the ones that ran
+func (h *OpenWebUIHandler) applyActions(req actionRequest, actx *ActionContext, actions []Action, phase ActionPhase) []Action {
+	var allowed []Action
+	for _, action := range actions {
+		if action.handler == nil || action.handler.Phase() != phase {
+			continue
+		}
+		if h.applyAction(req, actx, action).Outcome == actionAllowed {
+			allowed = append(allowed, action)
+		}
+	}
+
+	return allowed
+}
+
+// applyAction checks an action against the policy, then runs it, asks for confirmation or
+// drops it, recording the decision
+func (h *OpenWebUIHandler) applyAction(req actionRequest, actx *ActionContext, action Action) actionDecision {
+	if action.handler == nil {
+		return actionDecision{Outcome: actionDenied, Reason: "unknown action"}
+	}
+
+	if decision, denied := h.checkProfile(req, actx, action); denied {
+		return decision
+	}
+
+	policy := h.actionPolicy
+	phase := action.handler.Phase()
+	confirm := policy.confirm[action.Type] && phase != PhaseReplaceContent
+
+	deny := func(reason string) actionDecision {
+		policy.record(auditRecord(req, actx, action, "denied", reason))
+		return actionDecision{Outcome: actionDenied, Reason: reason}
+	}
+
+	// Destructive actions need the permission unless someone who has it approves them
+	if action.handler.Destructive() && !confirm && !h.discordClient.Authorize(req.GuildID, req.UserID, req.Member, PermissionDestructive) {
+		return deny("the user isn't allowed to trigger this action")
+	}
+	if !policy.allowRate(actx.ChannelID, action.Type) {
+		return deny("this action is being used too often in this channel")
+	}
+
+	if confirm {
+		// Without an allow list anyone could approve, including the user who asked
+		if !h.discordClient.Restricted(req.GuildID, PermissionDestructive) {
+			return deny("nobody is allowed to approve this action")
+		}
+		if err := h.requestConfirmation(req, actx, action); err != nil {
+			logger.Warn("Failed to ask for action confirmation", zap.Error(err), zap.String("type", string(action.Type)))
+			return deny("the confirmation couldn't be posted")
+		}
+		policy.record(auditRecord(req, actx, action, "pending", "waiting for confirmation"))
+		return actionDecision{Outcome: actionPending}
+	}
+
+	policy.record(auditRecord(req, actx, action, "allowed", ""))
+	performActions(actx, []Action{action}, phase)
+	return actionDecision{Outcome: actionAllowed}
+}
+
+// deferAction holds back an action until the reply is ready, denying it straight away
+// when the profile doesn't enable it so the model isn't told it will happen
+func (h *OpenWebUIHandler) deferAction(req actionRequest, actx *ActionContext, action Action) actionDecision {
+	if decision, denied := h.checkProfile(req, actx, action); denied {
+		return decision
+	}
+
+	return actionDecision{Outcome: actionDeferred}
+}
+
+// checkProfile denies and records an action the profile doesn't enable. Disabled actions
+// are never offered to the model, so asking for one may mean the prompt was tampered with.
+func (h *OpenWebUIHandler) checkProfile(req actionRequest, actx *ActionContext, action Action) (actionDecision, bool) {
+	if req.Profile == nil || req.Profile.actionEnabled(action.Type) {
+		return actionDecision{}, false
+	}
+
+	h.actionPolicy.record(auditRecord(req, actx, action, "denied", "denied: profile"))
+	return actionDecision{Outcome: actionDenied, Reason: "this action is disabled here"}, true
+}
+
+// requestConfirmation posts an action with buttons for a user with the destructive
+// permission to approve or deny it
+func (h *OpenWebUIHandler) requestConfirmation(req actionRequest, actx *ActionContext, action Action) error {
+	policy := h.actionPolicy
+
+	policy.mutex.Lock()
+	policy.nextID++
+	id := strconv.Itoa(policy.nextID)
+	policy.mutex.Unlock()
+
+	content := fmt.Sprintf("⚠️ I was asked to **%s** in reply to <@%s>", action.Type, req.UserID)
+	if action.Parameters != "" {
+		content += fmt.Sprintf(": `%s`", shortParameters(action.Parameters))
+	}
+	content += "\nSomeone allowed to run destructive actions can approve it."
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	messageID, err := h.discordClient.SendReplyWithComponents(ctx, actx.ChannelID, "", content, confirmButtons(id))
+	if err != nil {
+		return err
+	}
+
+	entry := &pendingAction{
+		action:    action,
+		actx:      *actx,
+		request:   req,
+		messageID: messageID,
+	}
+
+	policy.mutex.Lock()
+	policy.pending[id] = entry
+	entry.timer = time.AfterFunc(policy.options.ConfirmTimeout, func() { h.expireConfirmation(id) })
+	policy.mutex.Unlock()
+
+	return nil
+}
+
+// peekPending returns a pending action without removing it
+func (p *actionPolicy) peekPending(id string) *pendingAction {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.pending[id]
+}
+
+// takePending removes and returns a pending action
+func (p *actionPolicy) takePending(id string) *pendingAction {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	entry, exists := p.pending[id]
+	if !exists {
+		return nil
+	}
+	delete(p.pending, id)
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+
+	return entry
+}
+
+// expireConfirmation drops a confirmation nobody answered in time
+func (h *OpenWebUIHandler) expireConfirmation(id string) {
+	entry := h.actionPolicy.takePending(id)
+	if entry == nil {
+		return
+	}
+
+	h.actionPolicy.record(auditRecord(entry.request, &entry.actx, entry.action, "expired", "nobody approved it in time"))
+	h.closeConfirmation(entry, fmt.Sprintf("⌛ The request to **%s** expired.", entry.action.Type))
+}
+
+// closeConfirmation replaces a confirmation with its outcome and removes its buttons
+func (h *OpenWebUIHandler) closeConfirmation(entry *pendingAction, content string) {
+	s := h.discordClient.session
+	_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:              entry.messageID,
+		Channel:         entry.actx.ChannelID,
+		Content:         &content,
+		Components:      &[]discordgo.MessageComponent{},
+		AllowedMentions: allowedMentions(),
+	})
+	if err != nil {
+		logger.Warn("Failed to update action confirmation", zap.Error(err), zap.String("channel_id", entry.actx.ChannelID))
+	}
+}
+
+// Components routes presses on the confirmation buttons
+func (h *OpenWebUIHandler) Components() map[string]ComponentHandlerFunc {
+	return map[string]ComponentHandlerFunc{
+		confirmButtonPrefix: h.handleConfirmButton,
+	}
+}
+
+// handleConfirmButton approves or denies a pending action
+func (h *OpenWebUIHandler) handleConfirmButton(s *discordgo.Session, i *discordgo.InteractionCreate, customID string) {
+	user := interactionUser(i)
+	if user == nil {
+		return
+	}
+
+	verb, id, ok := strings.Cut(customID, ":")
+	if !ok || (verb != "approve" && verb != "deny") {
+		return
+	}
+
+	if !h.discordClient.Authorize(i.GuildID, user.ID, i.Member, PermissionDestructive) {
+		respondEphemeral(s, i, "You don't have permission to approve actions.")
+		return
+	}
+
+	if verb == "approve" {
+		if !h.discordClient.Restricted(i.GuildID, PermissionDestructive) {
+			respondEphemeral(s, i, "Actions can't be approved until the destructive permission has an allow list.")
+			return
+		}
+		// Whoever the action responds to may have asked the model for it, so they can't approve it
+		if entry := h.actionPolicy.peekPending(id); entry != nil && entry.request.UserID == user.ID {
+			respondEphemeral(s, i, "You can't approve an action requested in reply to you.")
+			return
+		}
+	}
+
+	entry := h.actionPolicy.takePending(id)
+	if entry == nil {
+		respondEphemeral(s, i, "That request has expired or was already answered.")
+		return
+	}
+
+	name := displayName(s, i.GuildID, i.Member, user)
+	decision, content := "rejected", fmt.Sprintf("❌ %s denied the request to **%s**.", name, entry.action.Type)
+	if verb == "approve" {
+		decision, content = "approved", fmt.Sprintf("✅ %s approved the request to **%s**.", name, entry.action.Type)
+	}
+
+	record := auditRecord(entry.request, &entry.actx, entry.action, decision, "")
+	record.DecidedBy = user.ID
+	h.actionPolicy.record(record)
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+	if err != nil {
+		logger.Warn("Failed to answer action confirmation", zap.Error(err), zap.String("channel_id", i.ChannelID))
+	}
+
+	// Interactions must be answered quickly, so the action runs after the response
+	if verb == "approve" {
+		performActions(&entry.actx, []Action{entry.action}, entry.action.handler.Phase())
+	}
+}
+
+// shortParameters shortens action parameters to quote them in a confirmation
+func shortParameters(params string) string {
+	runes := []rune(params)
+	if len(runes) <= 200 {
+		return params
+	}
+
+	return string(runes[:200]) + "…"
+}
+
+// confirmButtons returns the approve and deny buttons for a pending action
+func confirmButtons(id string) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Approve",
+					Style:    discordgo.SuccessButton,
+					CustomID: confirmButtonPrefix + "approve:" + id,
+				},
+				discordgo.Button{
+					Label:    "Deny",
+					Style:    discordgo.DangerButton,
+					CustomID: confirmButtonPrefix + "deny:" + id,
+				},
+			},
+		},
+	}
+}
+
+// describeActionAudit formats the latest audit records for the /actions command
+func describeActionAudit(records []ActionAuditRecord, limit int) string {
+	if len(records) == 0 {
+		return "No actions have been recorded in this channel."
+	}
+	if len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**Recent actions**\n")
+	for _, record := range records {
+		fmt.Fprintf(&sb, "`%s` **%s** for <@%s>: %s", record.Time.Format("15:04:05"), record.Type, record.UserID, record.Decision)
+		if record.DecidedBy != "" {
+			fmt.Fprintf(&sb, " by <@%s>", record.DecidedBy)
+		}
+		if record.Reason != "" {
+			fmt.Fprintf(&sb, " (%s)", record.Reason)
+		}
+		sb.WriteString("\n")
+	}
+
+	return strings.TrimSpace(sb.String())
+}
@@ -0,0 +1,164 @@
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"github.com/justmiles/openwebui-discord/internal/openwebui"
+	"github.com/justmiles/openwebui-discord/internal/prompt"
+	"go.uber.org/zap"
+)
+
+// ActionRegistry holds the actions the model can ask for. It parses markup and tool calls
+// into actions and describes the actions to the model, in registration order.
+type ActionRegistry struct {
+	handlers map[ActionType]ActionHandler
+	order    []ActionType
+	mutex    sync.RWMutex
+}
+
+// NewActionRegistry creates an empty action registry
+func NewActionRegistry() *ActionRegistry {
+	return &ActionRegistry{
+		handlers: make(map[ActionType]ActionHandler),
+	}
+}
+
+// DefaultActionRegistry creates a registry with the built-in actions
+func DefaultActionRegistry() *ActionRegistry {
+	registry := NewActionRegistry()
+	for _, handler := range builtinActions() {
+		if err := registry.Register(handler); err != nil {
+			panic(err)
+		}
+	}
+
+	return registry
+}
+
+// Register adds an action. Names are matched case-insensitively and must be letters only
+// so they can be written as markup.
+func (r *ActionRegistry) Register(handler ActionHandler) error {
+	name := ActionType(strings.ToLower(string(handler.Name())))
+	if name == "" || strings.IndexFunc(string(name), func(c rune) bool { return c < 'a' || c > 'z' }) >= 0 {
+		return fmt.Errorf("invalid action name %q", handler.Name())
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.handlers[name]; exists {
+		return fmt.Errorf("action %q is already registered", name)
+	}
+	r.handlers[name] = handler
+	r.order = append(r.order, name)
+
+	return nil
+}
+
+// Lookup returns the handler for an action type
+func (r *ActionRegistry) Lookup(actionType ActionType) (ActionHandler, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	handler, exists := r.handlers[ActionType(strings.ToLower(string(actionType)))]
+	return handler, exists
+}
+
+// enabledHandlers returns the registered handlers that enabled allows, in registration order
+func (r *ActionRegistry) enabledHandlers(enabled func(ActionType) bool) []ActionHandler {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	handlers := make([]ActionHandler, 0, len(r.order))
+	for _, name := range r.order {
+		if enabled(name) {
+			handlers = append(handlers, r.handlers[name])
+		}
+	}
+
+	return handlers
+}
+
+// Describe returns the descriptions of the actions that enabled allows
+func (r *ActionRegistry) Describe(enabled func(ActionType) bool) []prompt.ActionDescription {
+	handlers := r.enabledHandlers(enabled)
+	descriptions := make([]prompt.ActionDescription, 0, len(handlers))
+	for _, handler := range handlers {
+		description := handler.Describe()
+		description.Type = ActionType(strings.ToLower(string(handler.Name())))
+		descriptions = append(descriptions, description)
+	}
+
+	return descriptions
+}
+
+// Tools returns a function tool for each action that enabled allows
+func (r *ActionRegistry) Tools(enabled func(ActionType) bool) []openwebui.Tool {
+	var tools []openwebui.Tool
+	for _, description := range r.Describe(enabled) {
+		schema := description.Schema
+		if schema == nil {
+			schema = prompt.ObjectSchema(map[string]any{})
+		}
+		tools = append(tools, openwebui.NewFunctionTool(string(description.Type), description.Description, schema))
+	}
+
+	return tools
+}
+
+// Parse extracts registered actions from the LLM response and removes their markup,
+// including markup for unknown actions. Malformed markup is left in the response and
+// reported with its position.
+func (r *ActionRegistry) Parse(content string) ([]Action, string, []*MarkupError) {
+	result := scanMarkup(content)
+
+	// Extract actions
+	actions := make([]Action, 0, len(result.actions))
+	for _, found := range result.actions {
+		actionType := ActionType(strings.ToLower(found.Type)) // Normalize type to lowercase
+		handler, exists := r.Lookup(actionType)
+		if !exists {
+			logger.Warn("Unknown action type received", zap.String("type", string(actionType)))
+			continue
+		}
+
+		actions = append(actions, Action{
+			Type:       actionType,
+			Parameters: found.Parameters,
+			handler:    handler,
+		})
+		logger.Debug("Parsed action", zap.String("type", string(actionType)), zap.String("params", found.Parameters))
+	}
+
+	// Clean up any extra whitespace or newlines resulting from removal
+	return actions, strings.TrimSpace(result.clean), result.errors
+}
+
+// FromToolCall converts a tool call into the action its markup would have produced
+func (r *ActionRegistry) FromToolCall(call openwebui.ToolCall) (Action, error) {
+	actionType := ActionType(strings.ToLower(call.Function.Name))
+	handler, exists := r.Lookup(actionType)
+	if !exists {
+		return Action{}, fmt.Errorf("unknown action %q", call.Function.Name)
+	}
+
+	arguments := json.RawMessage(call.Function.Arguments)
+	if strings.TrimSpace(call.Function.Arguments) == "" {
+		arguments = json.RawMessage("{}")
+	}
+
+	params, err := handler.Parameters(arguments)
+	if err != nil {
+		return Action{}, err
+	}
+
+	return Action{
+		Type:       actionType,
+		Parameters: params,
+		handler:    handler,
+	}, nil
+}
@@ -1,9 +1,8 @@
 package discord
 
 import (
-	"regexp"
+	"encoding/json"
 	"strings"
-	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/justmiles/openwebui-discord/internal/logger"
@@ -26,140 +25,89 @@ const (
 	ActionFile      = prompt.ActionFile
 )
 
+// ActionPhase is when an action runs relative to sending the reply
+type ActionPhase int
+
+const (
+	// PhasePreSend actions act on the message being answered before the reply is sent
+	PhasePreSend ActionPhase = iota
+	// PhaseReplaceContent actions rewrite the reply before it is sent
+	PhaseReplaceContent
+	// PhasePostSend actions act on the reply once it has been sent
+	PhasePostSend
+)
+
+// ActionContext is what an action works with when it runs
+type ActionContext struct {
+	Session   *discordgo.Session
+	ChannelID string
+	// MessageID is the message being answered, which is empty for slash commands
+	MessageID string
+	// ReplyID is the bot's reply, which is only set for post-send actions
+	ReplyID string
+	// Content is the reply, which replace-content actions may change
+	Content string
+}
+
+// ActionHandler implements an action the model can ask for, through markup or a tool call
+type ActionHandler interface {
+	// Name is the action type used in markup and as the tool name
+	Name() ActionType
+	// Describe explains the action to the model
+	Describe() prompt.ActionDescription
+	// Phase is when the action runs
+	Phase() ActionPhase
+	// Destructive reports whether the action needs PermissionDestructive
+	Destructive() bool
+	// Parameters converts tool call arguments into the action's markup parameters
+	Parameters(arguments json.RawMessage) (string, error)
+	// Execute performs the action with its markup parameters
+	Execute(actx *ActionContext, params string) error
+}
+
 // Action represents a parsed action from the LLM response
 type Action struct {
 	Type       ActionType
 	Parameters string
+
+	handler ActionHandler
 }
 
-// ParseActions extracts actions from the LLM response
-func ParseActions(content string) ([]Action, string) {
-	// Regex to match action markup: [ACTION:type|parameters]
-	// It captures the type (letters only) and parameters (anything until ']')
-	actionRegex := regexp.MustCompile(`\[ACTION:([a-zA-Z]+)\|([^\]]+)\]`)
-
-	// Find all matches
-	matches := actionRegex.FindAllStringSubmatch(content, -1)
-
-	// Extract actions
-	actions := make([]Action, 0, len(matches))
-	for _, match := range matches {
-		if len(match) >= 3 {
-			actionType := ActionType(strings.ToLower(match[1])) // Normalize type to lowercase
-			parameters := match[2]
-			actions = append(actions, Action{
-				Type:       actionType,
-				Parameters: parameters,
-			})
-			logger.Debug("Parsed action", zap.String("type", string(actionType)), zap.String("params", parameters))
-		}
+// StripActions removes complete and partially streamed action markup from content
+// so it can be shown to users before the full response has arrived
+func StripActions(content string) string {
+	result := scanMarkup(content)
+	content = result.clean
+
+	// Hide an action that hasn't been closed yet
+	if result.open >= 0 {
+		content = content[:result.open]
 	}
 
-	// Remove action markup from content
-	cleanContent := actionRegex.ReplaceAllString(content, "")
-	// Clean up any extra whitespace or newlines resulting from removal
-	cleanContent = strings.TrimSpace(cleanContent)
+	// Hide a trailing opener that is still arriving
+	if i := strings.LastIndex(content, "["); i >= 0 && strings.HasPrefix(actionOpener, content[i:]) {
+		content = content[:i]
+	}
 
-	return actions, cleanContent
+	return strings.TrimSpace(content)
 }
 
-// ExecuteActions performs the specified actions
-func ExecuteActions(s *discordgo.Session, channelID string, messageID string, actions []Action) {
+// performActions runs the actions for a phase in the order they were requested, logging
+// failures without stopping the remaining actions
+func performActions(actx *ActionContext, actions []Action, phase ActionPhase) {
 	for _, action := range actions {
+		if action.handler == nil || action.handler.Phase() != phase {
+			continue
+		}
+
 		logger.Info("Executing action", zap.String("type", string(action.Type)), zap.String("params", action.Parameters))
-		switch action.Type {
-		case ActionStatus:
-			// Update bot status
-			err := s.UpdateCustomStatus(action.Parameters)
-			if err != nil {
-				logger.Warn("Failed to update status via action", zap.Error(err), zap.String("status", action.Parameters))
-			}
-
-		case ActionReact:
-			// Add reaction to the original user message
-			err := s.MessageReactionAdd(channelID, messageID, action.Parameters)
-			if err != nil {
-				// Log error but don't stop processing other actions
-				logger.Warn("Failed to add reaction via action", zap.Error(err), zap.String("emoji", action.Parameters), zap.String("channel_id", channelID), zap.String("message_id", messageID))
-			}
-
-		case ActionSilence:
-			// This is handled during message sending in handler.go
-			logger.Debug("Silence action engaged - LLM decided not to respond to this message", zap.String("params", action.Parameters))
-
-		case ActionFormat:
-			// This is handled during message sending in handler.go
-			// The format action is parsed and applied to the message content
-			logger.Debug("Format action detected", zap.String("params", action.Parameters))
-			// No direct action needed here as formatting will be applied when sending the message
-
-		case ActionReactions:
-			// Add multiple reactions in sequence
-			emojis := strings.Split(action.Parameters, "|")
-			for _, emoji := range emojis {
-				emoji = strings.TrimSpace(emoji)
-				if emoji == "" {
-					continue
-				}
-
-				err := s.MessageReactionAdd(channelID, messageID, emoji)
-				if err != nil {
-					logger.Warn("Failed to add reaction in sequence", zap.Error(err), zap.String("emoji", emoji))
-				}
-				// Small delay between reactions to avoid rate limiting
-				time.Sleep(300 * time.Millisecond)
-			}
-
-		case ActionDelete:
-			// Delete the bot's previous message
-			// We need to find the bot's previous message
-			if action.Parameters == "previous" {
-				messages, err := s.ChannelMessages(channelID, 10, "", "", "")
-				if err != nil {
-					logger.Warn("Failed to fetch messages for delete action", zap.Error(err))
-					break
-				}
-
-				// Find the most recent message from the bot
-				for _, msg := range messages {
-					if msg.Author.ID == s.State.User.ID && msg.ID != messageID {
-						err := s.ChannelMessageDelete(channelID, msg.ID)
-						if err != nil {
-							logger.Warn("Failed to delete previous message", zap.Error(err), zap.String("message_id", msg.ID))
-						} else {
-							logger.Info("Deleted previous message", zap.String("message_id", msg.ID))
-						}
-						break
-					}
-				}
-			}
-
-		case ActionPin:
-			// Pin the message that will be sent
-			// We'll need to handle this after the message is sent
-			// Store the action for processing after message is sent
-			logger.Debug("Pin action detected, will be processed after message is sent", zap.String("params", action.Parameters))
-			// This will be handled in handler.go after sending the message
-
-		case ActionFile:
-			// Generate and upload a file
-			parts := strings.SplitN(action.Parameters, "|", 2)
-			if len(parts) != 2 {
-				logger.Warn("Invalid file action format", zap.String("params", action.Parameters))
-				break
-			}
-
-			filename := strings.TrimSpace(parts[0])
-			content := parts[1]
-
-			reader := strings.NewReader(content)
-			_, err := s.ChannelFileSend(channelID, filename, reader)
-			if err != nil {
-				logger.Warn("Failed to upload file", zap.Error(err), zap.String("filename", filename))
-			}
-
-		default:
-			logger.Warn("Unknown action type received", zap.String("type", string(action.Type)))
+		if err := action.handler.Execute(actx, action.Parameters); err != nil {
+			logger.Warn("Failed to execute action",
+				zap.Error(err),
+				zap.String("type", string(action.Type)),
+				zap.String("params", action.Parameters),
+				zap.String("channel_id", actx.ChannelID),
+			)
 		}
 	}
 }
@@ -0,0 +1,382 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+	contextmgr "github.com/justmiles/openwebui-discord/internal/context"
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"github.com/justmiles/openwebui-discord/internal/openwebui"
+	"go.uber.org/zap"
+)
+
+const (
+	// ambientClassifierTimeout bounds how long the classifier can hold up a conversation
+	ambientClassifierTimeout = 15 * time.Second
+
+	// ambientClassifierHistory is how many earlier context messages the classifier sees
+	ambientClassifierHistory = 6
+
+	// ambientScoreBuckets is the number of equal-width buckets in the score histogram
+	ambientScoreBuckets = 10
+)
+
+// AmbientGate chooses how the bot decides whether to join conversations it wasn't addressed in
+type AmbientGate string
+
+const (
+	// AmbientGateOff sends every ambient message to the main model
+	AmbientGateOff AmbientGate = "off"
+	// AmbientGateHeuristic scores messages with heuristic rules
+	AmbientGateHeuristic AmbientGate = "heuristic"
+	// AmbientGateClassifier asks a small model, falling back to the heuristic rules if it fails
+	AmbientGateClassifier AmbientGate = "classifier"
+)
+
+// AmbientOptions controls how the bot decides whether to respond to messages that don't
+// address it while it is following a conversation
+type AmbientOptions struct {
+	Gate AmbientGate
+	// ClassifierModel is the model asked whether to respond, empty for the main model
+	ClassifierModel string
+	// Threshold is the score from 0 to 1 a message needs for the main model to be asked
+	Threshold float64
+
+	// WindowMinutes is how long the bot stays engaged after being addressed, unless a
+	// profile overrides it
+	WindowMinutes int
+	// CooldownMinutes is how long after the window the chance of replying fades out
+	CooldownMinutes int
+	// MaxReplies caps unsolicited replies per window, 0 for no cap
+	MaxReplies int
+	// ReplyProbability is the chance of considering an unaddressed message while engaged
+	ReplyProbability float64
+	// MaxSilences disengages after this many unanswered messages in a row, 0 to never
+	MaxSilences int
+}
+
+// AmbientStats is a snapshot of the ambient decisions made so far
+type AmbientStats struct {
+	Responded uint64
+	Skipped   uint64
+	// ClassifierErrors counts classifier failures that fell back to the heuristic rules
+	ClassifierErrors uint64
+	// ScoreHistogram counts decisions by score. Bucket i holds scores from i/10 up to,
+	// but not including, (i+1)/10, and the last bucket also holds scores of 1.
+	ScoreHistogram [ambientScoreBuckets]uint64
+}
+
+// ambientDecision is the outcome of the ambient gate for a burst of messages
+type ambientDecision struct {
+	Score   float64
+	Respond bool
+	Source  string
+	Reasons []string
+}
+
+// ambientGate scores ambient messages and keeps statistics about its decisions
+type ambientGate struct {
+	options    AmbientOptions
+	classifier *openwebui.Client
+	stats      AmbientStats
+	mutex      sync.Mutex
+}
+
+// newAmbientGate creates a gate, using client with the classifier model for classification
+func newAmbientGate(options AmbientOptions, client *openwebui.Client) *ambientGate {
+	gate := &ambientGate{options: options}
+
+	if options.Gate == AmbientGateClassifier {
+		model := options.ClassifierModel
+		if model == "" {
+			model = client.Model()
+		}
+
+		// The answer is a single number, so keep the request small and deterministic
+		temperature := 0.0
+		maxTokens := 8
+		gate.classifier = client.
+			WithModel(model).
+			WithToolIDs(nil).
+			WithParams(openwebui.GenerationParams{Temperature: &temperature, MaxTokens: &maxTokens})
+	}
+
+	return gate
+}
+
+// Decide works out whether the bot should respond to a burst of messages that don't address it
+func (g *ambientGate) Decide(s *discordgo.Session, messages []*discordgo.MessageCreate, history []contextmgr.Message) ambientDecision {
+	if g.options.Gate == AmbientGateOff || g.options.Gate == "" {
+		return ambientDecision{Score: 1, Respond: true, Source: "off"}
+	}
+
+	decision := heuristicDecision(s, messages)
+
+	if g.options.Gate == AmbientGateClassifier {
+		score, err := g.classify(s, messages, history)
+		if err != nil {
+			logger.Warn("Ambient classifier failed, falling back to heuristics", zap.Error(err))
+			g.mutex.Lock()
+			g.stats.ClassifierErrors++
+			g.mutex.Unlock()
+		} else {
+			decision.Score = score
+			decision.Source = "classifier"
+		}
+	}
+
+	decision.Respond = decision.Score >= g.options.Threshold
+	g.record(decision)
+
+	return decision
+}
+
+// record counts a decision in the statistics
+func (g *ambientGate) record(decision ambientDecision) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if decision.Respond {
+		g.stats.Responded++
+	} else {
+		g.stats.Skipped++
+	}
+
+	bucket := int(decision.Score * ambientScoreBuckets)
+	if bucket >= ambientScoreBuckets {
+		bucket = ambientScoreBuckets - 1
+	}
+	if bucket < 0 {
+		bucket = 0
+	}
+	g.stats.ScoreHistogram[bucket]++
+}
+
+// Stats returns a snapshot of the gate's decisions
+func (g *ambientGate) Stats() AmbientStats {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.stats
+}
+
+// heuristicDecision scores a burst with simple rules, keeping the best scoring message
+func heuristicDecision(s *discordgo.Session, messages []*discordgo.MessageCreate) ambientDecision {
+	best := ambientDecision{Score: -1, Source: "heuristic"}
+	for _, m := range messages {
+		score, reasons := heuristicScore(s, m)
+		if score > best.Score {
+			best.Score = score
+			best.Reasons = reasons
+		}
+	}
+
+	return best
+}
+
+// heuristicScore rates how likely a message is to want an answer from the bot, from 0 to 1
+func heuristicScore(s *discordgo.Session, m *discordgo.MessageCreate) (float64, []string) {
+	content := strings.ToLower(cleanMessage(s, m.Content))
+	score := 0.2
+	var reasons []string
+
+	if strings.Contains(content, "?") {
+		score += 0.3
+		reasons = append(reasons, "question")
+	}
+
+	botNames := []string{strings.ToLower(s.State.User.Username)}
+	if m.GuildID != "" {
+		botNames = append(botNames, strings.ToLower(displayName(s, m.GuildID, nil, s.State.User)))
+	}
+	for _, name := range botNames {
+		if name != "" && containsWord(content, name) {
+			score += 0.5
+			reasons = append(reasons, "bot name")
+			break
+		}
+	}
+
+	if m.ReferencedMessage != nil && m.ReferencedMessage.Author != nil {
+		if m.ReferencedMessage.Author.ID == s.State.User.ID {
+			score += 0.5
+			reasons = append(reasons, "reply to bot")
+		} else {
+			score -= 0.4
+			reasons = append(reasons, "reply to someone else")
+		}
+	} else if len(m.Mentions) > 0 {
+		score -= 0.4
+		reasons = append(reasons, "mentions someone else")
+	}
+
+	if len(strings.Fields(content)) <= 2 && !strings.Contains(content, "?") {
+		score -= 0.1
+		reasons = append(reasons, "short")
+	}
+
+	return clampScore(score), reasons
+}
+
+// classify asks the classifier model how likely it is that the bot should respond
+func (g *ambientGate) classify(s *discordgo.Session, messages []*discordgo.MessageCreate, history []contextmgr.Message) (float64, error) {
+	name := s.State.User.Username
+	if len(messages) > 0 && messages[0].GuildID != "" {
+		name = displayName(s, messages[0].GuildID, nil, s.State.User)
+	}
+
+	if len(history) > ambientClassifierHistory {
+		history = history[len(history)-ambientClassifierHistory:]
+	}
+
+	var transcript strings.Builder
+	for _, msg := range history {
+		speaker := msg.Name
+		if msg.Role == "assistant" {
+			speaker = name
+		} else if speaker == "" {
+			speaker = msg.Role
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", speaker, msg.Content)
+	}
+
+	var latest strings.Builder
+	for _, m := range messages {
+		fmt.Fprintf(&latest, "%s: %s\n", displayName(s, m.GuildID, m.Member, m.Author), resolveInboundMentions(s, m.GuildID, cleanMessage(s, m.Content)))
+	}
+
+	request := []openwebui.Message{
+		{
+			Role: "system",
+			Content: fmt.Sprintf("You decide whether %s, an assistant taking part in a Discord group chat, should reply to the latest messages. "+
+				"%s should reply to questions and requests meant for it or for anyone in the chat, and stay quiet when people are talking among themselves. "+
+				"Reply with only a number from 0 to 1: the probability that %s should reply.", name, name, name),
+		},
+		{
+			Role:    "user",
+			Content: fmt.Sprintf("Earlier messages:\n%s\nLatest messages:\n%s", transcript.String(), latest.String()),
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ambientClassifierTimeout)
+	defer cancel()
+
+	result, err := g.classifier.GetCompletion(ctx, request)
+	if err != nil {
+		return 0, fmt.Errorf("error classifying messages: %w", err)
+	}
+
+	fields := strings.Fields(result)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty classifier answer")
+	}
+	score, err := strconv.ParseFloat(strings.TrimRight(fields[0], "."), 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected classifier answer %q", result)
+	}
+
+	return clampScore(score), nil
+}
+
+// engagementPolicy returns the engagement policy for a conversation's profile
+func (h *OpenWebUIHandler) engagementPolicy(profile *effectiveProfile) contextmgr.EngagementPolicy {
+	return contextmgr.EngagementPolicy{
+		Window:                time.Duration(profile.AmbientWindowMinutes) * time.Minute,
+		Cooldown:              time.Duration(h.ambient.CooldownMinutes) * time.Minute,
+		MaxUnsolicitedReplies: h.ambient.MaxReplies,
+		ReplyProbability:      h.ambient.ReplyProbability,
+		MaxSilences:           h.ambient.MaxSilences,
+	}
+}
+
+// describeEngagement formats a channel's engagement for the /engagement command
+func describeEngagement(engagement contextmgr.Engagement, policy contextmgr.EngagementPolicy) string {
+	var sb strings.Builder
+
+	sb.WriteString("**Engagement**\n")
+	fmt.Fprintf(&sb, "State: %s\n", strings.ReplaceAll(string(engagement.State), "_", " "))
+	if engagement.EngagedAt.IsZero() {
+		sb.WriteString("Last addressed: never\n")
+	} else {
+		fmt.Fprintf(&sb, "Last addressed: <t:%d:R>\n", engagement.EngagedAt.Unix())
+	}
+	if engagement.State == contextmgr.EngagementIdle && engagement.Reason != "" {
+		fmt.Fprintf(&sb, "Disengaged because it %s\n", engagement.Reason)
+	}
+	fmt.Fprintf(&sb, "Window: %s, then %s cooling down\n", policy.Window, policy.Cooldown)
+	if policy.MaxUnsolicitedReplies > 0 {
+		fmt.Fprintf(&sb, "Unsolicited replies: %d of %d\n", engagement.UnsolicitedReplies, policy.MaxUnsolicitedReplies)
+	} else {
+		fmt.Fprintf(&sb, "Unsolicited replies: %d\n", engagement.UnsolicitedReplies)
+	}
+	if policy.MaxSilences > 0 {
+		fmt.Fprintf(&sb, "Silences in a row: %d of %d\n", engagement.ConsecutiveSilences, policy.MaxSilences)
+	} else {
+		fmt.Fprintf(&sb, "Silences in a row: %d\n", engagement.ConsecutiveSilences)
+	}
+	fmt.Fprintf(&sb, "Chance of considering a message: %.0f%%", policy.ReplyChance(engagement, time.Now())*100)
+
+	return sb.String()
+}
+
+// describeAmbientStats formats the ambient gate's decisions for the /engagement command,
+// so the threshold can be tuned against the scores messages actually get
+func describeAmbientStats(stats AmbientStats, options AmbientOptions) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "**Ambient gate** (%s, threshold %g, since the bot started)\n", options.Gate, options.Threshold)
+	fmt.Fprintf(&sb, "Decisions: %d answered, %d skipped", stats.Responded, stats.Skipped)
+	if options.Gate == AmbientGateClassifier {
+		fmt.Fprintf(&sb, ", %d classifier errors", stats.ClassifierErrors)
+	}
+
+	var buckets []string
+	for i, count := range stats.ScoreHistogram {
+		if count > 0 {
+			buckets = append(buckets, fmt.Sprintf("`%.1f` %d", float64(i)/ambientScoreBuckets, count))
+		}
+	}
+	if len(buckets) > 0 {
+		fmt.Fprintf(&sb, "\nScores: %s", strings.Join(buckets, ", "))
+	}
+
+	return sb.String()
+}
+
+// AmbientStats returns a snapshot of the decisions made about ambient messages
+func (h *OpenWebUIHandler) AmbientStats() AmbientStats {
+	return h.ambientGate.Stats()
+}
+
+// containsWord checks if text contains a word or phrase on word boundaries
+func containsWord(text, word string) bool {
+	words := strings.Join(strings.FieldsFunc(word, isWordSeparator), " ")
+	if words == "" {
+		return false
+	}
+
+	return strings.Contains(" "+strings.Join(strings.FieldsFunc(text, isWordSeparator), " ")+" ", " "+words+" ")
+}
+
+// isWordSeparator reports whether a rune separates words
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// clampScore keeps a score between 0 and 1
+func clampScore(score float64) float64 {
+	if score < 0 {
+		return 0
+	}
+	if score > 1 {
+		return 1
+	}
+
+	return score
+}
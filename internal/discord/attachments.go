@@ -0,0 +1,363 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	contextmgr "github.com/justmiles/openwebui-discord/internal/context"
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"github.com/justmiles/openwebui-discord/internal/openwebui"
+	"go.uber.org/zap"
+)
+
+// attachmentCacheSize is the number of downloaded attachments kept for follow-up questions
+const attachmentCacheSize = 32
+
+// AttachmentOptions controls which message attachments are forwarded to the model
+type AttachmentOptions struct {
+	// Images sends image attachments to the model, unless a profile turns vision off.
+	// Profiles can also turn it on for channels that use vision models.
+	Images bool
+	// MaxImageBytes is the largest image that will be downloaded
+	MaxImageBytes int
+	// MaxImages is the most images included in a single request, newest first
+	MaxImages int
+	// Text inlines text-like attachments such as logs, source files and configs
+	Text bool
+	// MaxTextBytes is how much of a text attachment is inlined before it is truncated
+	MaxTextBytes int
+}
+
+// textExtensions maps the extensions of text-like files to their code fence language
+var textExtensions = map[string]string{
+	".txt": "", ".log": "", ".md": "markdown", ".csv": "csv", ".tsv": "",
+	".go": "go", ".py": "python", ".js": "javascript", ".ts": "typescript", ".jsx": "jsx", ".tsx": "tsx",
+	".java": "java", ".kt": "kotlin", ".rs": "rust", ".c": "c", ".h": "c", ".cpp": "cpp", ".hpp": "cpp",
+	".cs": "csharp", ".rb": "ruby", ".php": "php", ".swift": "swift", ".scala": "scala", ".lua": "lua",
+	".sh": "bash", ".bash": "bash", ".zsh": "bash", ".ps1": "powershell", ".sql": "sql",
+	".json": "json", ".yaml": "yaml", ".yml": "yaml", ".toml": "toml", ".xml": "xml", ".html": "html",
+	".css": "css", ".ini": "ini", ".cfg": "ini", ".conf": "", ".env": "", ".properties": "properties",
+	".diff": "diff", ".patch": "diff", ".tf": "hcl", ".hcl": "hcl", ".proto": "protobuf", ".graphql": "graphql",
+	".mod": "", ".sum": "", ".gradle": "groovy", ".dockerfile": "dockerfile",
+}
+
+// textFilenames maps well-known extensionless text files to their code fence language
+var textFilenames = map[string]string{
+	"dockerfile":  "dockerfile",
+	"makefile":    "makefile",
+	"jenkinsfile": "groovy",
+}
+
+// textContentTypes are non text/* MIME types that hold text
+var textContentTypes = []string{
+	"application/json",
+	"application/xml",
+	"application/yaml",
+	"application/x-yaml",
+	"application/toml",
+	"application/javascript",
+	"application/x-sh",
+	"application/sql",
+}
+
+// attachmentFetcher downloads attachments from Discord's CDN and caches them so images
+// referenced again in later turns don't need to be downloaded again
+type attachmentFetcher struct {
+	client *http.Client
+	cache  map[string][]byte
+	order  []string
+	mutex  sync.Mutex
+}
+
+// newAttachmentFetcher creates an attachment fetcher with an empty cache
+func newAttachmentFetcher() *attachmentFetcher {
+	return &attachmentFetcher{
+		client: &http.Client{Timeout: 30 * time.Second},
+		cache:  make(map[string][]byte),
+	}
+}
+
+// Fetch downloads an attachment, refusing anything larger than maxBytes
+func (f *attachmentFetcher) Fetch(ctx context.Context, url string, maxBytes int) ([]byte, error) {
+	f.mutex.Lock()
+	data, cached := f.cache[url]
+	f.mutex.Unlock()
+	if cached {
+		return data, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating attachment request: %w", err)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading attachment: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading attachment: status %d", resp.StatusCode)
+	}
+
+	// Read one byte past the limit to detect oversized files
+	data, err = io.ReadAll(io.LimitReader(resp.Body, int64(maxBytes)+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading attachment: %w", err)
+	}
+	if len(data) > maxBytes {
+		return nil, fmt.Errorf("attachment exceeds %d bytes", maxBytes)
+	}
+
+	f.store(url, data)
+	return data, nil
+}
+
+// FetchPrefix downloads up to maxBytes of an attachment without caching it
+func (f *attachmentFetcher) FetchPrefix(ctx context.Context, url string, maxBytes int) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating attachment request: %w", err)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading attachment: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading attachment: status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxBytes)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading attachment: %w", err)
+	}
+
+	return data, nil
+}
+
+// store caches a downloaded attachment, evicting the oldest entry when the cache is full
+func (f *attachmentFetcher) store(url string, data []byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, exists := f.cache[url]; !exists {
+		f.cache[url] = data
+		f.order = append(f.order, url)
+		if len(f.order) > attachmentCacheSize {
+			delete(f.cache, f.order[0])
+			f.order = f.order[1:]
+		}
+	}
+}
+
+// isImageAttachment reports whether an attachment is an image
+func isImageAttachment(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/")
+}
+
+// textAttachmentLanguage reports whether an attachment holds text and the code fence
+// language to label it with
+func textAttachmentLanguage(filename, contentType string) (string, bool) {
+	name := strings.ToLower(filename)
+	if language, ok := textFilenames[name]; ok {
+		return language, true
+	}
+	if language, ok := textExtensions[path.Ext(name)]; ok {
+		return language, true
+	}
+
+	// Ignore parameters such as charset
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	if strings.HasPrefix(mediaType, "text/") {
+		return "", true
+	}
+	for _, textType := range textContentTypes {
+		if mediaType == textType {
+			return "", true
+		}
+	}
+
+	return "", false
+}
+
+// looksLikeText reports whether downloaded data is text rather than a mislabelled binary file
+func looksLikeText(data []byte) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return false
+	}
+
+	// A truncated download may end partway through a multi-byte character
+	for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+		data = data[:len(data)-1]
+	}
+
+	return utf8.Valid(data)
+}
+
+// fenceText wraps text in a code fence labelled with the filename, using a fence longer
+// than any backtick run inside the text so the block can't be closed early
+func fenceText(filename, language, text string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+
+	return fmt.Sprintf("%s:\n%s%s\n%s\n%s", filename, fence, language, strings.TrimRight(text, "\n"), fence)
+}
+
+// ingestAttachments runs a message's attachments through the ingestion pipeline. Text
+// attachments are returned inlined as fenced blocks, images are returned as references
+// for vision models, and everything else is described in a note so the model knows
+// the file exists.
+func (h *OpenWebUIHandler) ingestAttachments(ctx context.Context, attachments []*discordgo.MessageAttachment) (string, []contextmgr.Attachment) {
+	var blocks []string
+	var images []contextmgr.Attachment
+
+	for _, attachment := range attachments {
+		// Images are kept as references and only sent when the profile has vision
+		if isImageAttachment(attachment.ContentType) {
+			if attachment.Size > h.attachments.MaxImageBytes {
+				blocks = append(blocks, fmt.Sprintf("[Image %s was not included because it is larger than %d bytes]", attachment.Filename, h.attachments.MaxImageBytes))
+				continue
+			}
+
+			images = append(images, contextmgr.Attachment{
+				Filename:    attachment.Filename,
+				URL:         attachment.URL,
+				ContentType: attachment.ContentType,
+				Size:        attachment.Size,
+			})
+			continue
+		}
+
+		language, isText := textAttachmentLanguage(attachment.Filename, attachment.ContentType)
+		if !isText || !h.attachments.Text {
+			blocks = append(blocks, fmt.Sprintf("[File %s (%s, %d bytes) was attached but its contents were not included because it is not a text file]",
+				attachment.Filename, attachmentType(attachment.ContentType), attachment.Size))
+			continue
+		}
+
+		data, err := h.attachmentFetcher.FetchPrefix(ctx, attachment.URL, h.attachments.MaxTextBytes)
+		if err != nil {
+			logger.Warn("Failed to download text attachment",
+				zap.String("filename", attachment.Filename),
+				zap.Error(err),
+			)
+			blocks = append(blocks, fmt.Sprintf("[File %s could not be downloaded]", attachment.Filename))
+			continue
+		}
+
+		if !looksLikeText(data) {
+			blocks = append(blocks, fmt.Sprintf("[File %s (%d bytes) was attached but its contents were not included because it appears to be binary]",
+				attachment.Filename, attachment.Size))
+			continue
+		}
+
+		text := string(data)
+		if attachment.Size > len(data) {
+			// Drop a partial trailing character and mark where the file was cut
+			text = strings.ToValidUTF8(text, "")
+			text += fmt.Sprintf("\n[… truncated, showing the first %d of %d bytes]", len(data), attachment.Size)
+		}
+
+		blocks = append(blocks, fenceText(attachment.Filename, language, text))
+
+		logger.Debug("Ingested text attachment",
+			zap.String("filename", attachment.Filename),
+			zap.Int("bytes", len(data)),
+			zap.Int("size", attachment.Size),
+		)
+	}
+
+	return strings.Join(blocks, "\n\n"), images
+}
+
+// attachmentType describes an attachment's content type for notes to the model
+func attachmentType(contentType string) string {
+	if contentType == "" {
+		return "unknown type"
+	}
+
+	return contentType
+}
+
+// imageNotes describes a message's image attachments to a model that can't see them
+func imageNotes(attachments []contextmgr.Attachment) string {
+	var notes []string
+	for _, attachment := range attachments {
+		if isImageAttachment(attachment.ContentType) {
+			notes = append(notes, fmt.Sprintf("[Image %s was attached but images are not supported]", attachment.Filename))
+		}
+	}
+
+	return strings.Join(notes, "\n")
+}
+
+// withoutImages replaces the image parts in messages with notes, for models that reject them
+func withoutImages(messages []openwebui.Message) ([]openwebui.Message, bool) {
+	stripped := make([]openwebui.Message, len(messages))
+	found := false
+	for i, message := range messages {
+		stripped[i] = message
+		if len(message.Parts) == 0 {
+			continue
+		}
+
+		found = true
+		stripped[i].Parts = nil
+		for range message.Parts {
+			stripped[i].Content = strings.TrimSpace(stripped[i].Content + "\n[An attached image could not be shown to the model]")
+		}
+	}
+
+	return stripped, found
+}
+
+// imageParts downloads a message's image attachments and returns them as content parts.
+// Images beyond the remaining allowance, or that can no longer be downloaded, are
+// described in the returned note instead.
+func (h *OpenWebUIHandler) imageParts(ctx context.Context, attachments []contextmgr.Attachment, remaining *int) ([]openwebui.ContentPart, string) {
+	var parts []openwebui.ContentPart
+	var notes []string
+
+	for _, attachment := range attachments {
+		if !isImageAttachment(attachment.ContentType) {
+			continue
+		}
+
+		if *remaining <= 0 {
+			notes = append(notes, fmt.Sprintf("[Earlier image %s is no longer shown]", attachment.Filename))
+			continue
+		}
+
+		data, err := h.attachmentFetcher.Fetch(ctx, attachment.URL, h.attachments.MaxImageBytes)
+		if err != nil {
+			logger.Warn("Failed to download image attachment",
+				zap.String("filename", attachment.Filename),
+				zap.Error(err),
+			)
+			notes = append(notes, fmt.Sprintf("[Image %s could not be loaded]", attachment.Filename))
+			continue
+		}
+
+		dataURI := fmt.Sprintf("data:%s;base64,%s", attachment.ContentType, base64.StdEncoding.EncodeToString(data))
+		parts = append(parts, openwebui.NewImagePart(dataURI))
+		*remaining--
+	}
+
+	return parts, strings.Join(notes, "\n")
+}
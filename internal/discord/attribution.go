@@ -0,0 +1,87 @@
+package discord
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	contextmgr "github.com/justmiles/openwebui-discord/internal/context"
+	"github.com/justmiles/openwebui-discord/internal/openwebui"
+)
+
+// AttributionMode controls how speakers are identified in messages sent to OpenWebUI
+type AttributionMode string
+
+const (
+	// AttributionName sets the message name field
+	AttributionName AttributionMode = "name"
+	// AttributionPrefix prefixes the content with the speaker's name
+	AttributionPrefix AttributionMode = "prefix"
+	// AttributionBoth sets the name field and prefixes the content
+	AttributionBoth AttributionMode = "both"
+)
+
+// maxNameLength is the longest name the chat completions API accepts
+const maxNameLength = 64
+
+// attributeMessage converts a context message to an API message, identifying the speaker
+// of user messages according to the attribution mode
+func attributeMessage(msg contextmgr.Message, mode AttributionMode) openwebui.Message {
+	apiMsg := openwebui.Message{
+		Role:    msg.Role,
+		Content: msg.Content,
+	}
+
+	if msg.Role != "user" || msg.Name == "" {
+		return apiMsg
+	}
+
+	if mode == AttributionName || mode == AttributionBoth {
+		apiMsg.Name = sanitizeName(msg.Name)
+	}
+	if mode == AttributionPrefix || mode == AttributionBoth {
+		apiMsg.Content = fmt.Sprintf("%s: %s", msg.Name, msg.Content)
+	}
+
+	return apiMsg
+}
+
+// sanitizeName restricts a display name to the characters allowed in the API name field
+func sanitizeName(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		if sb.Len() >= maxNameLength {
+			break
+		}
+
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+
+	return sb.String()
+}
+
+// displayName returns the name a user is shown as, preferring their guild nickname,
+// then their global display name, then their username
+func displayName(s *discordgo.Session, guildID string, member *discordgo.Member, user *discordgo.User) string {
+	if member != nil && member.Nick != "" {
+		return member.Nick
+	}
+
+	// Fall back to the state cache when the event didn't include the member
+	if member == nil && guildID != "" {
+		if cached, err := s.State.Member(guildID, user.ID); err == nil && cached.Nick != "" {
+			return cached.Nick
+		}
+	}
+
+	if user.GlobalName != "" {
+		return user.GlobalName
+	}
+
+	return user.Username
+}
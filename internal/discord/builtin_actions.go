@@ -0,0 +1,370 @@
+package discord
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"github.com/justmiles/openwebui-discord/internal/prompt"
+	"go.uber.org/zap"
+)
+
+// builtinAction implements ActionHandler from plain values
+type builtinAction struct {
+	description prompt.ActionDescription
+	phase       ActionPhase
+	destructive bool
+	// needsMessage actions act on the message being answered and are skipped without one
+	needsMessage bool
+	parameters   func(args toolArguments) (string, error)
+	execute      func(actx *ActionContext, params string) error
+}
+
+func (a *builtinAction) Name() ActionType                   { return a.description.Type }
+func (a *builtinAction) Describe() prompt.ActionDescription { return a.description }
+func (a *builtinAction) Phase() ActionPhase                 { return a.phase }
+func (a *builtinAction) Destructive() bool                  { return a.destructive }
+
+func (a *builtinAction) Parameters(arguments json.RawMessage) (string, error) {
+	var args toolArguments
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	return a.parameters(args)
+}
+
+func (a *builtinAction) Execute(actx *ActionContext, params string) error {
+	if a.needsMessage && actx.MessageID == "" {
+		logger.Debug("Skipped action without a message to act on", zap.String("type", string(a.description.Type)))
+		return nil
+	}
+
+	return a.execute(actx, params)
+}
+
+// toolArguments holds the arguments of any built-in action's tool call
+type toolArguments struct {
+	Text     string   `json:"text"`
+	Emoji    string   `json:"emoji"`
+	Emojis   []string `json:"emojis"`
+	Style    string   `json:"style"`
+	Language string   `json:"language"`
+	Content  string   `json:"content"`
+	Reason   string   `json:"reason"`
+	Filename string   `json:"filename"`
+}
+
+// required returns an error naming the argument when its value is empty
+func required(value, name string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("%s is required", name)
+	}
+
+	return nil
+}
+
+// builtinActions returns the actions the bot ships with, in the order they're described
+func builtinActions() []ActionHandler {
+	return []ActionHandler{
+		&builtinAction{
+			description: prompt.ActionDescription{
+				Type:        ActionStatus,
+				Description: "Changes the bot's status message displayed in Discord.",
+				Parameters:  "A single string representing the status text to display.",
+				Examples: []string{
+					"[ACTION:status|Playing chess]",
+					"[ACTION:status|Listening to music]",
+					"[ACTION:status|Watching tutorials]",
+				},
+				Limitations:   "Status changes may not be immediately visible to all users due to Discord's caching.",
+				BestPractices: "Keep status messages concise and relevant to the current conversation or bot's purpose.",
+				Schema: prompt.ObjectSchema(map[string]any{
+					"text": prompt.StringSchema("The status text to display, kept short."),
+				}, "text"),
+			},
+			phase: PhasePreSend,
+			// The status is shown in every guild the bot is in
+			destructive: true,
+			parameters: func(args toolArguments) (string, error) {
+				return args.Text, required(args.Text, "text")
+			},
+			execute: func(actx *ActionContext, params string) error {
+				return actx.Session.UpdateCustomStatus(params)
+			},
+		},
+		&builtinAction{
+			description: prompt.ActionDescription{
+				Type:        ActionSilence,
+				Description: "Not all messages are for the bot. If it is not appropriate to respond, include this action.",
+				Parameters:  "boolean",
+				Examples: []string{
+					"[ACTION:silence|true]",
+				},
+				Limitations:   "None. This should be used generously.",
+				BestPractices: "Unless addressed directly or something bot can help please use this to stay silent.",
+			},
+			phase: PhaseReplaceContent,
+			parameters: func(args toolArguments) (string, error) {
+				return "true", nil
+			},
+			execute: func(actx *ActionContext, params string) error {
+				logger.Debug("Silence action engaged - LLM decided not to respond to this message", zap.String("params", params))
+				actx.Content = ""
+				return nil
+			},
+		},
+		&builtinAction{
+			description: prompt.ActionDescription{
+				Type:        ActionReact,
+				Description: "Adds a single emoji reaction to the user's message.",
+				Parameters:  "A single emoji (Unicode emoji or Discord custom emoji ID).",
+				Examples: []string{
+					"[ACTION:react|👍]",
+					"[ACTION:react|❤️]",
+					"[ACTION:react|🎉]",
+				},
+				Limitations:   "Some custom emojis may only work if the bot has access to the server they're from.",
+				BestPractices: "Use reactions to acknowledge user messages or provide quick feedback without sending a text response.",
+				Schema: prompt.ObjectSchema(map[string]any{
+					"emoji": prompt.StringSchema("A Unicode emoji or Discord custom emoji ID."),
+				}, "emoji"),
+			},
+			phase:        PhasePreSend,
+			needsMessage: true,
+			parameters: func(args toolArguments) (string, error) {
+				return args.Emoji, required(args.Emoji, "emoji")
+			},
+			execute: func(actx *ActionContext, params string) error {
+				return actx.Session.MessageReactionAdd(actx.ChannelID, actx.MessageID, params)
+			},
+		},
+		&builtinAction{
+			description: prompt.ActionDescription{
+				Type:        ActionFormat,
+				Description: "Applies special formatting to the bot's message.",
+				Parameters:  "Format type followed by content, separated by '|'. Format types include: code, bold, italic, quote.",
+				Examples: []string{
+					"[ACTION:format|code:python|print(\"Hello World\")]",
+					"[ACTION:format|bold|Important information]",
+					"[ACTION:format|italic|Emphasized text]",
+					"[ACTION:format|quote|This is a quote]",
+				},
+				Limitations:   "Formatting may not be combined (e.g., can't have bold and italic together).",
+				BestPractices: "Use code formatting when sharing code snippets to improve readability.",
+				Schema: prompt.ObjectSchema(map[string]any{
+					"style": map[string]any{
+						"type":        "string",
+						"description": "How to format the content.",
+						"enum":        []string{"code", "bold", "italic", "quote"},
+					},
+					"content":  prompt.StringSchema("The content to format, which becomes the reply."),
+					"language": prompt.StringSchema("The language of a code block, such as python."),
+				}, "style", "content"),
+			},
+			phase: PhaseReplaceContent,
+			parameters: func(args toolArguments) (string, error) {
+				if err := errors.Join(required(args.Style, "style"), required(args.Content, "content")); err != nil {
+					return "", err
+				}
+				if args.Style == "code" {
+					return "code:" + args.Language + "|" + args.Content, nil
+				}
+				return args.Style + "|" + args.Content, nil
+			},
+			execute: formatReply,
+		},
+		&builtinAction{
+			description: prompt.ActionDescription{
+				Type:        ActionReactions,
+				Description: "Adds multiple emoji reactions in sequence to the user's message.",
+				Parameters:  "Multiple emojis separated by '|'.",
+				Examples: []string{
+					"[ACTION:reactions|👍|❤️|🎉]",
+					"[ACTION:reactions|1️⃣|2️⃣|3️⃣]",
+				},
+				Limitations:   "Limited to a reasonable number of reactions to avoid rate limiting.",
+				BestPractices: "Use sequential reactions for creating simple polls or showing a sequence of emotions.",
+				Schema: prompt.ObjectSchema(map[string]any{
+					"emojis": map[string]any{
+						"type":        "array",
+						"description": "The emojis to add, in order.",
+						"items":       map[string]any{"type": "string"},
+					},
+				}, "emojis"),
+			},
+			phase:        PhasePreSend,
+			needsMessage: true,
+			parameters: func(args toolArguments) (string, error) {
+				emojis := strings.Join(args.Emojis, "|")
+				return emojis, required(emojis, "emojis")
+			},
+			execute: addReactions,
+		},
+		&builtinAction{
+			description: prompt.ActionDescription{
+				Type:        ActionPin,
+				Description: "Pins the bot's reply in the channel.",
+				Parameters:  "A short reason the reply is worth keeping.",
+				Examples: []string{
+					"[ACTION:pin|Meeting notes]",
+				},
+				Limitations:   "Needs permission to manage messages, and channels can only hold a limited number of pins.",
+				BestPractices: "Only pin replies people will want to find again, such as summaries or decisions.",
+				Schema: prompt.ObjectSchema(map[string]any{
+					"reason": prompt.StringSchema("Why the reply is worth pinning."),
+				}),
+			},
+			phase:       PhasePostSend,
+			destructive: true,
+			parameters: func(args toolArguments) (string, error) {
+				return args.Reason, nil
+			},
+			execute: func(actx *ActionContext, params string) error {
+				if err := actx.Session.ChannelMessagePin(actx.ChannelID, actx.ReplyID); err != nil {
+					return err
+				}
+				logger.Info("Pinned message", zap.String("message_id", actx.ReplyID))
+				return nil
+			},
+		},
+		&builtinAction{
+			description: prompt.ActionDescription{
+				Type:        ActionDelete,
+				Description: "Deletes the bot's previous message in the channel.",
+				Parameters:  "The word previous.",
+				Examples: []string{
+					"[ACTION:delete|previous]",
+				},
+				Limitations:   "Only the bot's own most recent message among the last few in the channel can be deleted.",
+				BestPractices: "Use this to take back a mistaken or unwanted reply when asked to.",
+				Schema:        prompt.ObjectSchema(map[string]any{}),
+			},
+			phase:        PhasePreSend,
+			destructive:  true,
+			needsMessage: true,
+			parameters: func(args toolArguments) (string, error) {
+				return "previous", nil
+			},
+			execute: deletePreviousMessage,
+		},
+		&builtinAction{
+			description: prompt.ActionDescription{
+				Type:        ActionFile,
+				Description: "Uploads a text file to the channel.",
+				Parameters:  "The file name followed by the file's contents, separated by '|'.",
+				Examples: []string{
+					"[ACTION:file|notes.md|# Notes]",
+				},
+				Limitations:   "Unbalanced brackets in the contents must be escaped in markup, and large files may be rejected by Discord.",
+				BestPractices: "Use files for content too long or structured for a message, such as logs or data.",
+				Schema: prompt.ObjectSchema(map[string]any{
+					"filename": prompt.StringSchema("The file name, including its extension."),
+					"content":  prompt.StringSchema("The file's contents."),
+				}, "filename", "content"),
+			},
+			phase:       PhasePreSend,
+			destructive: true,
+			parameters: func(args toolArguments) (string, error) {
+				return args.Filename + "|" + args.Content, required(args.Filename, "filename")
+			},
+			execute: uploadFile,
+		},
+	}
+}
+
+// formatReply replaces the reply with formatted content: format|type|content, where code
+// takes its language as code:language|content or code|language|content
+func formatReply(actx *ActionContext, params string) error {
+	parts := strings.SplitN(params, "|", 2)
+	if len(parts) < 2 {
+		return fmt.Errorf("invalid format action: %s", params)
+	}
+	formatType, content := parts[0], parts[1]
+
+	switch {
+	case formatType == "code":
+		langParts := strings.SplitN(content, "|", 2)
+		if len(langParts) < 2 {
+			return fmt.Errorf("invalid code format action: %s", params)
+		}
+		actx.Content = "```" + langParts[0] + "\n" + langParts[1] + "\n```"
+	case strings.HasPrefix(formatType, "code:"):
+		actx.Content = "```" + strings.TrimPrefix(formatType, "code:") + "\n" + content + "\n```"
+	case formatType == "bold":
+		actx.Content = "**" + content + "**"
+	case formatType == "italic":
+		actx.Content = "*" + content + "*"
+	case formatType == "quote":
+		lines := strings.Split(content, "\n")
+		for i, line := range lines {
+			lines[i] = "> " + line
+		}
+		actx.Content = strings.Join(lines, "\n")
+	default:
+		return fmt.Errorf("unknown format type: %s", formatType)
+	}
+
+	logger.Debug("Applied formatting", zap.String("type", formatType))
+	return nil
+}
+
+// addReactions adds several reactions in sequence to the message being answered
+func addReactions(actx *ActionContext, params string) error {
+	for _, emoji := range strings.Split(params, "|") {
+		emoji = strings.TrimSpace(emoji)
+		if emoji == "" {
+			continue
+		}
+
+		err := actx.Session.MessageReactionAdd(actx.ChannelID, actx.MessageID, emoji)
+		if err != nil {
+			logger.Warn("Failed to add reaction in sequence", zap.Error(err), zap.String("emoji", emoji))
+		}
+		// Small delay between reactions to avoid rate limiting
+		time.Sleep(300 * time.Millisecond)
+	}
+
+	return nil
+}
+
+// deletePreviousMessage deletes the bot's most recent message before the one being answered
+func deletePreviousMessage(actx *ActionContext, params string) error {
+	if params != "previous" {
+		return fmt.Errorf("unsupported delete target: %s", params)
+	}
+
+	// Only look before the message being answered, so the reply and any confirmation
+	// posted for this action are never deleted
+	messages, err := actx.Session.ChannelMessages(actx.ChannelID, 10, actx.MessageID, "", "")
+	if err != nil {
+		return fmt.Errorf("error fetching messages: %w", err)
+	}
+
+	// Find the most recent message from the bot
+	for _, msg := range messages {
+		if msg.Author.ID == actx.Session.State.User.ID && msg.ID != actx.MessageID {
+			if err := actx.Session.ChannelMessageDelete(actx.ChannelID, msg.ID); err != nil {
+				return fmt.Errorf("error deleting message %s: %w", msg.ID, err)
+			}
+			logger.Info("Deleted previous message", zap.String("message_id", msg.ID))
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// uploadFile uploads the file in file|filename|content to the channel
+func uploadFile(actx *ActionContext, params string) error {
+	parts := strings.SplitN(params, "|", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid file action: %s", params)
+	}
+
+	_, err := actx.Session.ChannelFileSend(actx.ChannelID, strings.TrimSpace(parts[0]), strings.NewReader(parts[1]))
+	return err
+}
@@ -0,0 +1,296 @@
+package discord
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"go.uber.org/zap"
+)
+
+// stopButtonPrefix starts the custom ID of the Stop button, followed by the triggering message ID
+const stopButtonPrefix = "stop:"
+
+// stopReactions are the reactions on a triggering message that stop its generation
+var stopReactions = map[string]bool{
+	"❌": true,
+	"🛑": true,
+}
+
+// InFlightRequest describes a generation that is still being answered
+type InFlightRequest struct {
+	// ChannelID is where the conversation is answered
+	ChannelID string
+	// ParentChannelID is where the triggering message was sent when it is answered in a
+	// thread started for it
+	ParentChannelID string
+	// MessageID is the message that triggered the generation, empty for slash commands
+	MessageID string
+	// UserID is who asked
+	UserID    string
+	StartedAt time.Time
+}
+
+// inFlight is a registered generation along with what's needed to stop it
+type inFlight struct {
+	InFlightRequest
+	cancel    context.CancelFunc
+	stopped   bool
+	stoppedBy string
+}
+
+// inFlightRegistry tracks the generations being answered in each channel so they can be stopped
+type inFlightRegistry struct {
+	requests map[string][]*inFlight
+	mutex    sync.Mutex
+}
+
+// newInFlightRegistry creates an empty registry
+func newInFlightRegistry() *inFlightRegistry {
+	return &inFlightRegistry{requests: make(map[string][]*inFlight)}
+}
+
+// start registers a generation and returns a context that is cancelled when it's stopped.
+// The returned function must be called once the generation is done, after which it can no
+// longer be stopped.
+func (r *inFlightRegistry) start(parent context.Context, request InFlightRequest) (context.Context, *inFlight, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	request.StartedAt = time.Now()
+	entry := &inFlight{InFlightRequest: request, cancel: cancel}
+
+	r.mutex.Lock()
+	r.requests[request.ChannelID] = append(r.requests[request.ChannelID], entry)
+	r.mutex.Unlock()
+
+	return ctx, entry, func() {
+		cancel()
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		requests := r.requests[request.ChannelID]
+		for i, existing := range requests {
+			if existing == entry {
+				requests = append(requests[:i], requests[i+1:]...)
+				break
+			}
+		}
+		if len(requests) == 0 {
+			delete(r.requests, request.ChannelID)
+		} else {
+			r.requests[request.ChannelID] = requests
+		}
+	}
+}
+
+// stopper reports whether a generation was stopped and the name of whoever stopped it
+func (r *inFlightRegistry) stopper(entry *inFlight) (string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return entry.stoppedBy, entry.stopped
+}
+
+// byMessage returns the generation triggered by a message
+func (r *inFlightRegistry) byMessage(messageID string) *inFlight {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, requests := range r.requests {
+		for _, entry := range requests {
+			if entry.MessageID == messageID {
+				return entry
+			}
+		}
+	}
+
+	return nil
+}
+
+// inChannel returns the generations being answered in a channel, including those that
+// were asked for in it and moved to a thread
+func (r *inFlightRegistry) inChannel(channelID string) []*inFlight {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entries := append([]*inFlight(nil), r.requests[channelID]...)
+	for id, requests := range r.requests {
+		if id == channelID {
+			continue
+		}
+		for _, entry := range requests {
+			// Threads started from a message share its ID
+			if entry.ParentChannelID == channelID || entry.MessageID == channelID {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	return entries
+}
+
+// stop cancels a generation, remembering who stopped it for the note left in its place
+func (r *inFlightRegistry) stop(entry *inFlight, stoppedBy string) {
+	r.mutex.Lock()
+	if !entry.stopped {
+		entry.stopped = true
+		entry.stoppedBy = stoppedBy
+	}
+	r.mutex.Unlock()
+
+	entry.cancel()
+}
+
+// list returns every generation being answered, oldest first
+func (r *inFlightRegistry) list() []InFlightRequest {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var requests []InFlightRequest
+	for _, entries := range r.requests {
+		for _, entry := range entries {
+			requests = append(requests, entry.InFlightRequest)
+		}
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].StartedAt.Before(requests[j].StartedAt)
+	})
+
+	return requests
+}
+
+// InFlightRequests lists the generations currently being answered
+func (c *Client) InFlightRequests() []InFlightRequest {
+	return c.inFlight.list()
+}
+
+// canStop checks if a user may stop a generation: the user who asked can always stop it,
+// anyone else needs the admin permission
+func (c *Client) canStop(entry *inFlight, guildID, userID string, member *discordgo.Member) bool {
+	return entry.UserID == userID || c.Authorize(guildID, userID, member, PermissionAdmin)
+}
+
+// isStopCommand reports whether a message is the stop command
+func (c *Client) isStopCommand(content string) bool {
+	return strings.EqualFold(strings.TrimSpace(content), c.commandPrefix+"stop")
+}
+
+// handleStopCommand stops the generations in a channel that the author may stop
+func (c *Client) handleStopCommand(s *discordgo.Session, m *discordgo.MessageCreate) {
+	stopped := 0
+	for _, entry := range c.inFlight.inChannel(m.ChannelID) {
+		if !c.canStop(entry, m.GuildID, m.Author.ID, m.Member) {
+			continue
+		}
+
+		c.inFlight.stop(entry, displayName(s, m.GuildID, m.Member, m.Author))
+		stopped++
+	}
+
+	logger.Info("Stop command received",
+		zap.String("channel_id", m.ChannelID),
+		zap.String("user_id", m.Author.ID),
+		zap.Int("stopped", stopped),
+	)
+
+	if stopped == 0 {
+		c.sendReply(m.ChannelID, m.ID, "There's nothing here for you to stop.")
+	}
+}
+
+// reactionHandler stops a generation when a stop reaction is added to the message that triggered it
+func (c *Client) reactionHandler(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	if r.UserID == s.State.User.ID || !stopReactions[r.Emoji.Name] {
+		return
+	}
+
+	entry := c.inFlight.byMessage(r.MessageID)
+	if entry == nil || !c.canStop(entry, r.GuildID, r.UserID, r.Member) {
+		return
+	}
+
+	logger.Info("Stop reaction received",
+		zap.String("channel_id", r.ChannelID),
+		zap.String("message_id", r.MessageID),
+		zap.String("user_id", r.UserID),
+	)
+
+	c.inFlight.stop(entry, reactionUserName(s, r))
+}
+
+// handleStopButton stops the generation a Stop button belongs to
+func (c *Client) handleStopButton(s *discordgo.Session, i *discordgo.InteractionCreate, messageID string) {
+	user := interactionUser(i)
+	if user == nil {
+		return
+	}
+
+	entry := c.inFlight.byMessage(messageID)
+	if entry == nil {
+		respondEphemeral(s, i, "That response has already finished.")
+		return
+	}
+	if !c.canStop(entry, i.GuildID, user.ID, i.Member) {
+		respondEphemeral(s, i, "Only the person who asked can stop this response.")
+		return
+	}
+
+	logger.Info("Stop button pressed",
+		zap.String("channel_id", i.ChannelID),
+		zap.String("message_id", messageID),
+		zap.String("user_id", user.ID),
+	)
+
+	c.inFlight.stop(entry, displayName(s, i.GuildID, i.Member, user))
+
+	// The note replaces the message, so the press only needs acknowledging
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+	if err != nil {
+		logger.Warn("Failed to acknowledge stop button", zap.Error(err), zap.String("channel_id", i.ChannelID))
+	}
+}
+
+// reactionUserName returns the display name of the user who added a reaction
+func reactionUserName(s *discordgo.Session, r *discordgo.MessageReactionAdd) string {
+	if r.Member != nil && r.Member.User != nil {
+		return displayName(s, r.GuildID, r.Member, r.Member.User)
+	}
+
+	user, err := s.User(r.UserID)
+	if err != nil {
+		return ""
+	}
+
+	return displayName(s, r.GuildID, nil, user)
+}
+
+// stopButton returns the Stop button for a generation triggered by a message
+func stopButton(messageID string) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Stop",
+					Style:    discordgo.DangerButton,
+					CustomID: stopButtonPrefix + messageID,
+				},
+			},
+		},
+	}
+}
+
+// stoppedNote is left in place of a stopped generation
+func stoppedNote(stoppedBy string) string {
+	if stoppedBy == "" {
+		return "*Stopped.*"
+	}
+
+	return "*Stopped by " + stoppedBy + ".*"
+}
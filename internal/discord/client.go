@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/bwmarrin/discordgo"
+	contextmgr "github.com/justmiles/openwebui-discord/internal/context"
 	"github.com/justmiles/openwebui-discord/internal/logger"
 	"github.com/justmiles/openwebui-discord/internal/ratelimit"
 	"go.uber.org/zap"
@@ -20,8 +21,22 @@ type Client struct {
 	authorizedGuilds   []string
 	authorizedChannels []string
 	rateLimiter        *ratelimit.Limiter
+	messageLimiter     *ratelimit.HierarchicalLimiter
+	rateLimitNotices   *rateLimitNotices
+	queue              *workQueue
+	inFlight           *inFlightRegistry
+	debouncer          *debouncer
 	handlers           []Handler
 	handlersMutex      sync.RWMutex
+	commands           *CommandRegistry
+	syncedGuilds       map[string]bool
+	syncMutex          sync.Mutex
+	components         map[string]ComponentHandlerFunc
+	policy             AuthorizationPolicy
+
+	directMessages       DirectMessageOptions
+	directMessageLimiter *ratelimit.HierarchicalLimiter
+	memberships          *memberships
 }
 
 // Handler is an interface for message handlers
@@ -29,8 +44,14 @@ type Handler interface {
 	HandleMessage(s *discordgo.Session, m *discordgo.MessageCreate)
 }
 
+// BurstHandler is implemented by handlers that can answer a burst of consecutive
+// messages together instead of one at a time
+type BurstHandler interface {
+	HandleMessages(s *discordgo.Session, messages []*discordgo.MessageCreate)
+}
+
 // NewClient creates a new Discord client
-func NewClient(token, commandPrefix string, authorizedGuilds, authorizedChannels []string, requestsPerMinute int) (*Client, error) {
+func NewClient(token, commandPrefix string, authorizedGuilds, authorizedChannels []string, rateLimits ratelimit.Limits, directMessages DirectMessageOptions, policy AuthorizationPolicy, queue QueueOptions) (*Client, error) {
 	// Create Discord session
 	session, err := discordgo.New("Bot " + token)
 	if err != nil {
@@ -43,12 +64,32 @@ func NewClient(token, commandPrefix string, authorizedGuilds, authorizedChannels
 		commandPrefix:      commandPrefix,
 		authorizedGuilds:   authorizedGuilds,
 		authorizedChannels: authorizedChannels,
-		rateLimiter:        ratelimit.NewLimiter(requestsPerMinute),
+		rateLimiter:        rateLimits.GlobalLimiter(),
+		messageLimiter:     ratelimit.NewHierarchicalLimiter(rateLimits),
+		rateLimitNotices:   newRateLimitNotices(),
+		queue:              newWorkQueue(queue),
+		inFlight:           newInFlightRegistry(),
 		handlers:           make([]Handler, 0),
+		commands:           NewCommandRegistry(),
+		syncedGuilds:       make(map[string]bool),
+		components:         make(map[string]ComponentHandlerFunc),
+		policy:             policy,
+
+		directMessages:       directMessages,
+		directMessageLimiter: ratelimit.NewHierarchicalLimiter(ratelimit.Limits{User: directMessages.RequestsPerMinute}),
+		memberships:          newMemberships(),
+	}
+
+	// Coalesce message bursts when a debounce window is set
+	if queue.Debounce > 0 {
+		client.debouncer = newDebouncer(queue.Debounce)
 	}
 
-	// Add message handler
+	// Add message, interaction, reaction and guild handlers
 	session.AddHandler(client.messageHandler)
+	session.AddHandler(client.interactionHandler)
+	session.AddHandler(client.reactionHandler)
+	session.AddHandler(client.guildCreateHandler)
 
 	return client, nil
 }
@@ -72,6 +113,9 @@ func (c *Client) Start(ctx context.Context) error {
 		logger.Warn("Failed to update status", zap.Error(err))
 	}
 
+	// Register slash commands
+	c.syncCommands()
+
 	// Wait for context to be done
 	<-ctx.Done()
 
@@ -84,6 +128,160 @@ func (c *Client) AddHandler(handler Handler) {
 	c.handlersMutex.Lock()
 	defer c.handlersMutex.Unlock()
 	c.handlers = append(c.handlers, handler)
+
+	// Register any slash commands the handler provides
+	if provider, ok := handler.(CommandProvider); ok {
+		c.commands.Register(provider.Commands()...)
+	}
+
+	// Route presses on the components the handler sends
+	if provider, ok := handler.(ComponentProvider); ok {
+		for prefix, handle := range provider.Components() {
+			c.components[prefix] = handle
+		}
+	}
+}
+
+// RegisterCommands adds slash commands to be synced on Start
+func (c *Client) RegisterCommands(commands ...*Command) {
+	c.commands.Register(commands...)
+}
+
+// syncCommands registers the declared slash commands with each guild the bot serves
+func (c *Client) syncCommands() {
+	guildIDs := c.authorizedGuilds
+	if len(guildIDs) == 0 {
+		for _, guild := range c.session.State.Guilds {
+			guildIDs = append(guildIDs, guild.ID)
+		}
+	}
+
+	for _, guildID := range guildIDs {
+		c.syncGuild(guildID)
+	}
+}
+
+// syncGuild registers the declared slash commands with a guild unless they already were
+func (c *Client) syncGuild(guildID string) {
+	c.syncMutex.Lock()
+	if c.syncedGuilds[guildID] {
+		c.syncMutex.Unlock()
+		return
+	}
+	c.syncedGuilds[guildID] = true
+	c.syncMutex.Unlock()
+
+	if err := c.commands.Sync(c.session, guildID); err != nil {
+		logger.Warn("Failed to sync slash commands", zap.Error(err), zap.String("guild_id", guildID))
+
+		// Try again the next time the guild becomes available
+		c.syncMutex.Lock()
+		delete(c.syncedGuilds, guildID)
+		c.syncMutex.Unlock()
+	}
+}
+
+// guildCreateHandler registers slash commands with guilds the bot joins or that become
+// available after it started
+func (c *Client) guildCreateHandler(s *discordgo.Session, g *discordgo.GuildCreate) {
+	if len(c.authorizedGuilds) > 0 && !containsID(c.authorizedGuilds, g.ID) {
+		return
+	}
+
+	c.syncGuild(g.ID)
+}
+
+// routeComponent sends a component press to the stop button or the handler that sent it
+func (c *Client) routeComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	customID := i.MessageComponentData().CustomID
+	if messageID, ok := strings.CutPrefix(customID, stopButtonPrefix); ok {
+		c.handleStopButton(s, i, messageID)
+		return
+	}
+
+	c.handlersMutex.RLock()
+	var handle ComponentHandlerFunc
+	var id string
+	for prefix, candidate := range c.components {
+		if rest, ok := strings.CutPrefix(customID, prefix); ok {
+			handle, id = candidate, rest
+			break
+		}
+	}
+	c.handlersMutex.RUnlock()
+
+	if handle != nil {
+		handle(s, i, id)
+		return
+	}
+
+	logger.Warn("Received unknown component interaction", zap.String("custom_id", customID))
+}
+
+// interactionHandler routes slash command interactions to their registered handlers
+func (c *Client) interactionHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type == discordgo.InteractionMessageComponent {
+		c.routeComponent(s, i)
+		return
+	}
+
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	name := i.ApplicationCommandData().Name
+	cmd, exists := c.commands.Get(name)
+	if !exists {
+		logger.Warn("Received unknown slash command", zap.String("command", name))
+		return
+	}
+
+	// Check if the interaction is from an authorized guild/channel
+	if !c.isAuthorized(i.GuildID, i.ChannelID) {
+		respondEphemeral(s, i, "I'm not available in this channel.")
+		return
+	}
+
+	user := interactionUser(i)
+	if user == nil {
+		return
+	}
+
+	// Check the user may run the command
+	permission := cmd.Permission
+	if permission == "" {
+		permission = PermissionChat
+	}
+	if !c.Authorize(i.GuildID, user.ID, i.Member, permission) {
+		// Interactions always need a response, so fall back to a generic one
+		response := c.policy.DenyResponse
+		if response == "" {
+			response = "You don't have permission to use this command."
+		}
+		respondEphemeral(s, i, response)
+		return
+	}
+
+	// Apply rate limiting
+	key := ratelimit.Key{GuildID: i.GuildID, ChannelID: i.ChannelID, UserID: user.ID}
+	if allowed, rejection := c.messageLimiter.Allow(key); !allowed {
+		logger.Warn("Rate limit exceeded for slash command",
+			zap.String("channel_id", i.ChannelID),
+			zap.String("user_id", user.ID),
+			zap.String("level", string(rejection.Level)),
+			zap.Duration("retry_after", rejection.RetryAfter),
+		)
+		respondEphemeral(s, i, rateLimitMessage(rejection))
+		return
+	}
+
+	logger.Debug("Handling slash command",
+		zap.String("command", name),
+		zap.String("channel_id", i.ChannelID),
+		zap.String("user_id", user.ID),
+	)
+
+	cmd.Handler(s, i)
 }
 
 // messageHandler handles incoming Discord messages
@@ -93,8 +291,21 @@ func (c *Client) messageHandler(s *discordgo.Session, m *discordgo.MessageCreate
 		return
 	}
 
-	// Check if the message is from an authorized guild/channel
-	if !c.isAuthorized(m.GuildID, m.ChannelID) {
+	// Direct messages have their own access rules, otherwise check if the message is
+	// from an authorized guild/channel
+	key := ratelimit.Key{GuildID: m.GuildID, ChannelID: m.ChannelID, UserID: m.Author.ID}
+	isDirect := isDirectMessage(m)
+	if isDirect {
+		// Rate limit before checking access, since checking guild membership can need the API
+		if allowed, rejection := c.directMessageLimiter.Allow(key); !allowed {
+			c.rateLimited(m, rejection, func() bool { return c.isDirectMessageAuthorized(m.Author.ID) })
+			return
+		}
+		if !c.isDirectMessageAuthorized(m.Author.ID) {
+			logger.Debug("Ignoring unauthorized direct message", zap.String("user_id", m.Author.ID))
+			return
+		}
+	} else if !c.isAuthorized(m.GuildID, m.ChannelID) {
 		return
 	}
 
@@ -109,6 +320,20 @@ func (c *Client) messageHandler(s *discordgo.Session, m *discordgo.MessageCreate
 
 	isCommand := strings.HasPrefix(m.Content, c.commandPrefix)
 
+	// Check the user may talk to the bot, only answering denials addressed to it
+	if !c.Authorize(m.GuildID, m.Author.ID, m.Member, PermissionChat) {
+		if c.policy.DenyResponse != "" && (isMention || isCommand || isDirect) {
+			c.sendReply(m.ChannelID, m.ID, c.policy.DenyResponse)
+		}
+		return
+	}
+
+	// Stop commands skip rate limiting and the queue, since they target the message being answered
+	if c.isStopCommand(m.Content) {
+		c.handleStopCommand(s, m)
+		return
+	}
+
 	// Always process the message, but log if it's not a direct mention or command
 	if !isMention && !isCommand {
 		logger.Debug("Processing message without direct mention or command",
@@ -117,28 +342,101 @@ func (c *Client) messageHandler(s *discordgo.Session, m *discordgo.MessageCreate
 		)
 	}
 
-	// Apply rate limiting
-	if !c.rateLimiter.Allow() {
-		logger.Warn("Rate limit exceeded for Discord message",
+	// Apply rate limiting, direct messages having been limited separately already
+	if !isDirect {
+		if allowed, rejection := c.messageLimiter.Allow(key); !allowed {
+			c.rateLimited(m, rejection, func() bool { return isMention || isCommand })
+			return
+		}
+	}
+
+	// Messages in the same conversation are queued behind each other so they are handled in order
+	queueKey := m.ChannelID
+	if isDirect {
+		queueKey = contextmgr.DirectMessageKey(m.Author.ID)
+	}
+	isAddressed := isMention || isCommand || isDirect
+
+	// Gather bursts of messages so they are answered together
+	if c.debouncer != nil {
+		c.debouncer.Add(queueKey, m, isAddressed, func(messages []*discordgo.MessageCreate, addressed bool) {
+			c.enqueue(s, queueKey, messages, addressed)
+		})
+		return
+	}
+
+	c.enqueue(s, queueKey, []*discordgo.MessageCreate{m}, isAddressed)
+}
+
+// rateLimited logs a rejected message and tells the user when they can try again, once
+// per cooldown, if addressed reports that the message was meant for a bot they may use
+func (c *Client) rateLimited(m *discordgo.MessageCreate, rejection *ratelimit.Rejection, addressed func() bool) {
+	logger.Warn("Rate limit exceeded for Discord message",
+		zap.String("channel_id", m.ChannelID),
+		zap.String("user_id", m.Author.ID),
+		zap.String("level", string(rejection.Level)),
+		zap.Duration("retry_after", rejection.RetryAfter),
+	)
+
+	if addressed() && c.rateLimitNotices.shouldNotify(m.Author.ID, rejection) {
+		c.sendReply(m.ChannelID, m.ID, rateLimitMessage(rejection))
+	}
+}
+
+// enqueue queues messages to be handled together behind others with the same key, telling
+// users who addressed the bot when they have to wait
+func (c *Client) enqueue(s *discordgo.Session, queueKey string, messages []*discordgo.MessageCreate, isAddressed bool) {
+	ahead, queued := c.queue.Enqueue(queueKey, func() {
+		// Process messages with all registered handlers
+		c.handlersMutex.RLock()
+		handlers := c.handlers
+		c.handlersMutex.RUnlock()
+
+		for _, handler := range handlers {
+			if burstHandler, ok := handler.(BurstHandler); ok {
+				burstHandler.HandleMessages(s, messages)
+				continue
+			}
+			for _, m := range messages {
+				handler.HandleMessage(s, m)
+			}
+		}
+	})
+
+	// Notices reply to the latest message
+	m := messages[len(messages)-1]
+	if !queued {
+		logger.Warn("Message queue full, dropping message",
 			zap.String("channel_id", m.ChannelID),
 			zap.String("user_id", m.Author.ID),
+			zap.Int("depth", ahead),
+			zap.Int("messages", len(messages)),
 		)
-		c.sendMessage(m.ChannelID, "I'm receiving too many messages right now. Please try again later.")
+		if isAddressed {
+			c.sendReply(m.ChannelID, m.ID, "I'm too busy to take more messages here right now. Please try again in a moment.")
+		}
 		return
 	}
 
-	// Process message with all registered handlers
-	c.handlersMutex.RLock()
-	handlers := c.handlers
-	c.handlersMutex.RUnlock()
-
-	for _, handler := range handlers {
-		handler.HandleMessage(s, m)
+	if ahead > 0 {
+		logger.Debug("Queued Discord message",
+			zap.String("channel_id", m.ChannelID),
+			zap.String("user_id", m.Author.ID),
+			zap.Int("position", ahead),
+		)
+		if isAddressed {
+			c.sendReply(m.ChannelID, m.ID, queuePositionMessage(ahead))
+		}
 	}
 }
 
 // isAuthorized checks if a message is from an authorized guild/channel
 func (c *Client) isAuthorized(guildID, channelID string) bool {
+	// Direct messages are authorized separately
+	if guildID == "" {
+		return false
+	}
+
 	// If no authorized guilds/channels are specified, allow all
 	if len(c.authorizedGuilds) == 0 && len(c.authorizedChannels) == 0 {
 		return true
@@ -158,19 +456,107 @@ func (c *Client) isAuthorized(guildID, channelID string) bool {
 		}
 	}
 
+	// Threads inherit the permission of their parent channel
+	if len(c.authorizedChannels) > 0 {
+		if parentID := c.threadParentID(channelID); parentID != "" {
+			for _, authorizedChannel := range c.authorizedChannels {
+				if parentID == authorizedChannel {
+					return true
+				}
+			}
+		}
+	}
+
 	return false
 }
 
+// threadParentID returns the parent channel of a thread, or an empty string if the
+// channel is not a thread
+func (c *Client) threadParentID(channelID string) string {
+	channel := lookupChannel(c.session, channelID)
+	if channel == nil || !channel.IsThread() {
+		return ""
+	}
+
+	return channel.ParentID
+}
+
+// StartThread starts a public thread off a message and returns the thread's channel ID
+func (c *Client) StartThread(ctx context.Context, channelID, messageID, name string, autoArchiveMinutes int) (string, error) {
+	// Apply rate limiting
+	if err := c.rateLimiter.Wait(ctx); err != nil {
+		return "", fmt.Errorf("error waiting for rate limit: %w", err)
+	}
+
+	thread, err := c.session.MessageThreadStartComplex(channelID, messageID, &discordgo.ThreadStart{
+		Name:                name,
+		AutoArchiveDuration: autoArchiveMinutes,
+	})
+	if err != nil {
+		logger.Error("Failed to start Discord thread",
+			zap.String("channel_id", channelID),
+			zap.String("message_id", messageID),
+			zap.Error(err),
+		)
+		return "", fmt.Errorf("error starting thread: %w", err)
+	}
+
+	return thread.ID, nil
+}
+
 // SendMessage sends a message to a Discord channel
-func (c *Client) SendMessage(channelID, content string) (string, error) {
+func (c *Client) SendMessage(ctx context.Context, channelID, content string) (string, error) {
 	// Apply rate limiting
-	c.rateLimiter.Wait()
+	if err := c.rateLimiter.Wait(ctx); err != nil {
+		return "", fmt.Errorf("error waiting for rate limit: %w", err)
+	}
 
 	return c.sendMessage(channelID, content)
 }
 
+// SendReply sends a message to a Discord channel as a reply to another message.
+// If the message is split, only the first part is sent as a reply.
+func (c *Client) SendReply(ctx context.Context, channelID, replyToID, content string) (string, error) {
+	// Apply rate limiting
+	if err := c.rateLimiter.Wait(ctx); err != nil {
+		return "", fmt.Errorf("error waiting for rate limit: %w", err)
+	}
+
+	return c.sendReply(channelID, replyToID, content)
+}
+
+// SendReplyWithComponents sends a single message as a reply with components such as
+// buttons attached. The content must fit in one message.
+func (c *Client) SendReplyWithComponents(ctx context.Context, channelID, replyToID, content string, components []discordgo.MessageComponent) (string, error) {
+	// Apply rate limiting
+	if err := c.rateLimiter.Wait(ctx); err != nil {
+		return "", fmt.Errorf("error waiting for rate limit: %w", err)
+	}
+
+	data := &discordgo.MessageSend{Content: content, Components: components, AllowedMentions: allowedMentions()}
+	if replyToID != "" {
+		data.Reference = replyReference(channelID, replyToID)
+	}
+
+	msg, err := c.session.ChannelMessageSendComplex(channelID, data)
+	if err != nil {
+		logger.Error("Failed to send Discord message",
+			zap.String("channel_id", channelID),
+			zap.Error(err),
+		)
+		return "", fmt.Errorf("error sending message: %w", err)
+	}
+
+	return msg.ID, nil
+}
+
 // sendMessage sends a message to a Discord channel without rate limiting
 func (c *Client) sendMessage(channelID, content string) (string, error) {
+	return c.sendReply(channelID, "", content)
+}
+
+// sendReply sends a message without rate limiting, replying to replyToID if it is set
+func (c *Client) sendReply(channelID, replyToID, content string) (string, error) {
 	// Split message if it's too long
 	if len(content) > 2000 {
 		messages := splitMessage(content, 1900)
@@ -178,10 +564,10 @@ func (c *Client) sendMessage(channelID, content string) (string, error) {
 		var err error
 
 		for i, msg := range messages {
-			if i == len(messages)-1 {
-				lastMessageID, err = c.sendMessage(channelID, msg)
+			if i == 0 {
+				lastMessageID, err = c.sendReply(channelID, replyToID, msg)
 			} else {
-				_, err = c.sendMessage(channelID, msg)
+				lastMessageID, err = c.sendReply(channelID, "", msg)
 			}
 
 			if err != nil {
@@ -192,8 +578,13 @@ func (c *Client) sendMessage(channelID, content string) (string, error) {
 		return lastMessageID, nil
 	}
 
+	data := &discordgo.MessageSend{Content: content, AllowedMentions: allowedMentions()}
+	if replyToID != "" {
+		data.Reference = replyReference(channelID, replyToID)
+	}
+
 	// Send message
-	msg, err := c.session.ChannelMessageSend(channelID, content)
+	msg, err := c.session.ChannelMessageSendComplex(channelID, data)
 	if err != nil {
 		logger.Error("Failed to send Discord message",
 			zap.String("channel_id", channelID),
@@ -205,6 +596,80 @@ func (c *Client) sendMessage(channelID, content string) (string, error) {
 	return msg.ID, nil
 }
 
+// replyReference references the message being replied to, sending normally if it has
+// been deleted
+func replyReference(channelID, replyToID string) *discordgo.MessageReference {
+	failIfNotExists := false
+	return &discordgo.MessageReference{
+		MessageID:       replyToID,
+		ChannelID:       channelID,
+		FailIfNotExists: &failIfNotExists,
+	}
+}
+
+// allowedMentions lets the bot's messages ping users, roles and the author it replies to,
+// but never @everyone or @here, whatever the content says
+func allowedMentions() *discordgo.MessageAllowedMentions {
+	return &discordgo.MessageAllowedMentions{
+		Parse:       []discordgo.AllowedMentionType{discordgo.AllowedMentionTypeUsers, discordgo.AllowedMentionTypeRoles},
+		RepliedUser: true,
+	}
+}
+
+// EditMessage replaces the content of a message previously sent by the bot.
+// Edits are not counted against the message rate limit.
+func (c *Client) EditMessage(channelID, messageID, content string) error {
+	_, err := c.session.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:              messageID,
+		Channel:         channelID,
+		Content:         &content,
+		AllowedMentions: allowedMentions(),
+	})
+	if err != nil {
+		logger.Error("Failed to edit Discord message",
+			zap.String("channel_id", channelID),
+			zap.String("message_id", messageID),
+			zap.Error(err),
+		)
+		return fmt.Errorf("error editing message: %w", err)
+	}
+
+	return nil
+}
+
+// ClearComponents removes the components, such as buttons, from a message sent by the bot
+func (c *Client) ClearComponents(channelID, messageID string) error {
+	_, err := c.session.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:         messageID,
+		Channel:    channelID,
+		Components: &[]discordgo.MessageComponent{},
+	})
+	if err != nil {
+		logger.Warn("Failed to clear Discord message components",
+			zap.String("channel_id", channelID),
+			zap.String("message_id", messageID),
+			zap.Error(err),
+		)
+		return fmt.Errorf("error clearing message components: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteMessage deletes a message from a Discord channel
+func (c *Client) DeleteMessage(channelID, messageID string) error {
+	if err := c.session.ChannelMessageDelete(channelID, messageID); err != nil {
+		logger.Warn("Failed to delete Discord message",
+			zap.String("channel_id", channelID),
+			zap.String("message_id", messageID),
+			zap.Error(err),
+		)
+		return fmt.Errorf("error deleting message: %w", err)
+	}
+
+	return nil
+}
+
 // SetTyping sets the typing indicator in a Discord channel
 func (c *Client) SetTyping(channelID string) error {
 	return c.session.ChannelTyping(channelID)
@@ -244,6 +709,12 @@ func splitMessage(message string, maxLength int) []string {
 	return parts
 }
 
+// RateLimitStats returns snapshots of the outgoing message limiter and the global
+// level of the incoming message limiter
+func (c *Client) RateLimitStats() (outgoing, incoming ratelimit.Stats) {
+	return c.rateLimiter.Stats(), c.messageLimiter.GlobalStats()
+}
+
 // GetSession returns the underlying Discord session
 func (c *Client) GetSession() *discordgo.Session {
 	return c.session
@@ -0,0 +1,138 @@
+package discord
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"go.uber.org/zap"
+)
+
+// CommandHandlerFunc handles an invoked slash command
+type CommandHandlerFunc func(s *discordgo.Session, i *discordgo.InteractionCreate)
+
+// Command describes a slash command and the handler that serves it
+type Command struct {
+	Definition *discordgo.ApplicationCommand
+	Handler    CommandHandlerFunc
+	// Permission is what the authorization policy requires to run the command, chat when empty
+	Permission Permission
+}
+
+// CommandProvider is implemented by handlers that expose slash commands
+type CommandProvider interface {
+	Commands() []*Command
+}
+
+// ComponentHandlerFunc handles a press on a component whose custom ID starts with the
+// prefix it was registered under, receiving the rest of the ID
+type ComponentHandlerFunc func(s *discordgo.Session, i *discordgo.InteractionCreate, id string)
+
+// ComponentProvider is implemented by handlers that send their own components, keyed by
+// custom ID prefix
+type ComponentProvider interface {
+	Components() map[string]ComponentHandlerFunc
+}
+
+// CommandRegistry keeps the slash commands declared in code and routes interactions to them
+type CommandRegistry struct {
+	commands map[string]*Command
+	order    []string
+	mutex    sync.RWMutex
+}
+
+// NewCommandRegistry creates an empty command registry
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{
+		commands: make(map[string]*Command),
+	}
+}
+
+// Register adds commands to the registry, replacing any existing command with the same name
+func (r *CommandRegistry) Register(commands ...*Command) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, cmd := range commands {
+		name := cmd.Definition.Name
+		if _, exists := r.commands[name]; !exists {
+			r.order = append(r.order, name)
+		}
+		r.commands[name] = cmd
+	}
+}
+
+// Get returns the command registered under the given name
+func (r *CommandRegistry) Get(name string) (*Command, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	cmd, exists := r.commands[name]
+	return cmd, exists
+}
+
+// Definitions returns the application command definitions in registration order
+func (r *CommandRegistry) Definitions() []*discordgo.ApplicationCommand {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	definitions := make([]*discordgo.ApplicationCommand, 0, len(r.order))
+	for _, name := range r.order {
+		definitions = append(definitions, r.commands[name].Definition)
+	}
+
+	return definitions
+}
+
+// Sync overwrites the guild's registered commands with the declared set, so commands
+// that are no longer declared in code are removed from Discord
+func (r *CommandRegistry) Sync(s *discordgo.Session, guildID string) error {
+	definitions := r.Definitions()
+
+	registered, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, guildID, definitions)
+	if err != nil {
+		return fmt.Errorf("error syncing commands for guild %s: %w", guildID, err)
+	}
+
+	logger.Info("Synced slash commands",
+		zap.String("guild_id", guildID),
+		zap.Int("commands", len(registered)),
+	)
+
+	return nil
+}
+
+// commandOption returns the named option from an application command interaction
+func commandOption(i *discordgo.InteractionCreate, name string) (*discordgo.ApplicationCommandInteractionDataOption, bool) {
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == name {
+			return option, true
+		}
+	}
+
+	return nil, false
+}
+
+// interactionUser returns the user that triggered an interaction in a guild or DM
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+
+	return i.User
+}
+
+// respondEphemeral replies to an interaction with a message only the invoking user can see
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		logger.Warn("Failed to respond to interaction", zap.Error(err), zap.String("channel_id", i.ChannelID))
+	}
+}
@@ -0,0 +1,72 @@
+package discord
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// debounceMaxWindows caps how long a burst can keep growing, in debounce windows, so a
+// busy channel still gets answered
+const debounceMaxWindows = 5
+
+// debouncer gathers consecutive messages per conversation into bursts, flushing a burst
+// once no message has arrived for the debounce window
+type debouncer struct {
+	window time.Duration
+	bursts map[string]*burst
+	mutex  sync.Mutex
+}
+
+// burst is a set of messages waiting to be answered together
+type burst struct {
+	messages  []*discordgo.MessageCreate
+	addressed bool
+	started   time.Time
+	timer     *time.Timer
+}
+
+// newDebouncer creates a debouncer with the given window
+func newDebouncer(window time.Duration) *debouncer {
+	return &debouncer{
+		window: window,
+		bursts: make(map[string]*burst),
+	}
+}
+
+// Add adds a message to the key's burst, postponing the pending flush. flush receives the
+// burst's messages and whether any of them was addressed to the bot.
+func (d *debouncer) Add(key string, m *discordgo.MessageCreate, addressed bool, flush func(messages []*discordgo.MessageCreate, addressed bool)) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	current, exists := d.bursts[key]
+	if !exists {
+		current = &burst{started: time.Now()}
+		d.bursts[key] = current
+	}
+	current.messages = append(current.messages, m)
+	current.addressed = current.addressed || addressed
+
+	// Wait a full window after the latest message, but no longer than the cap allows
+	wait := d.window
+	if remaining := time.Until(current.started.Add(debounceMaxWindows * d.window)); remaining < wait {
+		wait = remaining
+	}
+
+	if current.timer != nil {
+		current.timer.Stop()
+	}
+	current.timer = time.AfterFunc(wait, func() {
+		d.mutex.Lock()
+		if d.bursts[key] != current {
+			d.mutex.Unlock()
+			return
+		}
+		delete(d.bursts, key)
+		d.mutex.Unlock()
+
+		flush(current.messages, current.addressed)
+	})
+}
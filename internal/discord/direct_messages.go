@@ -0,0 +1,152 @@
+package discord
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"go.uber.org/zap"
+)
+
+// DirectMessageAccess controls who can talk to the bot in direct messages
+type DirectMessageAccess string
+
+const (
+	// DirectMessageAllowlist only accepts direct messages from listed users
+	DirectMessageAllowlist DirectMessageAccess = "allowlist"
+	// DirectMessageGuildMembers accepts direct messages from members of the authorized guilds
+	DirectMessageGuildMembers DirectMessageAccess = "guild_members"
+)
+
+// DirectMessageOptions controls direct message conversations
+type DirectMessageOptions struct {
+	// Enabled turns on responses to direct messages
+	Enabled bool
+	// Access decides who can use direct messages
+	Access DirectMessageAccess
+	// AllowedUsers are the user IDs accepted by the allowlist
+	AllowedUsers []string
+	// RequestsPerMinute limits each user's direct messages separately from guild traffic
+	RequestsPerMinute int
+}
+
+// isDirectMessageAuthorized checks if a user may talk to the bot in direct messages
+func (c *Client) isDirectMessageAuthorized(userID string) bool {
+	if !c.directMessages.Enabled {
+		return false
+	}
+
+	switch c.directMessages.Access {
+	case DirectMessageAllowlist:
+		for _, allowedUser := range c.directMessages.AllowedUsers {
+			if userID == allowedUser {
+				return true
+			}
+		}
+		return false
+
+	case DirectMessageGuildMembers:
+		guildIDs := c.authorizedGuilds
+		if len(guildIDs) == 0 {
+			for _, guild := range c.session.State.Guilds {
+				guildIDs = append(guildIDs, guild.ID)
+			}
+		}
+
+		// The state cache costs nothing, so every guild is checked there before the API
+		for _, guildID := range guildIDs {
+			if _, err := c.session.State.Member(guildID, userID); err == nil {
+				return true
+			}
+		}
+
+		if member, exists := c.memberships.get(userID); exists {
+			return member
+		}
+
+		member := false
+		for _, guildID := range guildIDs {
+			if c.fetchGuildMember(guildID, userID) {
+				member = true
+				break
+			}
+		}
+		c.memberships.set(userID, member)
+
+		return member
+	}
+
+	return false
+}
+
+// fetchGuildMember asks the API whether a user is a member of a guild
+func (c *Client) fetchGuildMember(guildID, userID string) bool {
+	member, err := c.session.GuildMember(guildID, userID)
+	if err != nil {
+		return false
+	}
+
+	// Cache the member so the next direct message doesn't need the API
+	member.GuildID = guildID
+	if err := c.session.State.MemberAdd(member); err != nil {
+		logger.Debug("Failed to cache guild member", zap.String("guild_id", guildID), zap.Error(err))
+	}
+
+	return true
+}
+
+// membershipTTL is how long a direct message sender's guild membership is remembered
+const membershipTTL = 10 * time.Minute
+
+// membership is a remembered guild membership check
+type membership struct {
+	member  bool
+	expires time.Time
+}
+
+// memberships remembers whether direct message senders belong to an authorized guild, so
+// strangers sending many messages don't cost an API call per guild for each of them
+type memberships struct {
+	entries map[string]membership
+	mutex   sync.Mutex
+}
+
+// newMemberships creates an empty membership cache
+func newMemberships() *memberships {
+	return &memberships{entries: make(map[string]membership)}
+}
+
+// get returns a user's remembered membership, if it hasn't expired
+func (m *memberships) get(userID string) (member, exists bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry, exists := m.entries[userID]
+	if !exists || time.Now().After(entry.expires) {
+		return false, false
+	}
+
+	return entry.member, true
+}
+
+// set remembers a user's membership
+func (m *memberships) set(userID string, member bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Forget expired entries so the map doesn't grow with every user who ever sent one
+	now := time.Now()
+	for id, entry := range m.entries {
+		if now.After(entry.expires) {
+			delete(m.entries, id)
+		}
+	}
+
+	m.entries[userID] = membership{member: member, expires: now.Add(membershipTTL)}
+}
+
+// isDirectMessage reports whether a message was sent in a direct message
+func isDirectMessage(m *discordgo.MessageCreate) bool {
+	return m.GuildID == ""
+}
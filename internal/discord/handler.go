@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -15,211 +16,666 @@ import (
 
 // OpenWebUIHandler handles Discord messages and processes them with OpenWebUI
 type OpenWebUIHandler struct {
-	discordClient  *Client
-	openwebui      *openwebui.Client
-	contextManager *contextmgr.Manager
-	systemPrompt   string
+	discordClient     *Client
+	openwebui         *openwebui.Client
+	contextManager    *contextmgr.Manager
+	systemPrompt      string
+	dmSystemPrompt    string
+	streamResponses   bool
+	attribution       AttributionMode
+	attachments       AttachmentOptions
+	replyChainDepth   int
+	threads           ThreadOptions
+	profiles          Profiles
+	actionMode        ActionMode
+	actions           *ActionRegistry
+	actionPolicy      *actionPolicy
+	attachmentFetcher *attachmentFetcher
+	ambient           AmbientOptions
+	ambientGate       *ambientGate
+	overrides         map[string]*channelOverride
+	overridesMutex    sync.RWMutex
 }
 
-// NewOpenWebUIHandler creates a new OpenWebUI message handler
+// channelOverride holds per-channel settings changed through slash commands
+type channelOverride struct {
+	Model   string
+	Persona string
+}
+
+// NewOpenWebUIHandler creates a new OpenWebUI message handler. The system prompts are
+// personas, which are expanded with the instructions for each conversation's action mode.
 func NewOpenWebUIHandler(
 	discordClient *Client,
 	openwebuiClient *openwebui.Client,
 	contextManager *contextmgr.Manager,
 	systemPrompt string,
+	dmSystemPrompt string,
+	streamResponses bool,
+	attribution AttributionMode,
+	attachments AttachmentOptions,
+	replyChainDepth int,
+	threads ThreadOptions,
+	profiles Profiles,
+	actionMode ActionMode,
+	policy ActionPolicyOptions,
+	ambient AmbientOptions,
 ) *OpenWebUIHandler {
+	if ambient.WindowMinutes <= 0 {
+		ambient.WindowMinutes = defaultAmbientWindowMinutes
+	}
+	if actionMode == "" {
+		actionMode = ActionModeTools
+	}
+	if policy.ConfirmTimeout <= 0 {
+		policy.ConfirmTimeout = defaultConfirmTimeout
+	}
+
 	return &OpenWebUIHandler{
-		discordClient:  discordClient,
-		openwebui:      openwebuiClient,
-		contextManager: contextManager,
-		systemPrompt:   systemPrompt,
+		discordClient:     discordClient,
+		openwebui:         openwebuiClient,
+		contextManager:    contextManager,
+		systemPrompt:      systemPrompt,
+		dmSystemPrompt:    dmSystemPrompt,
+		streamResponses:   streamResponses,
+		attribution:       attribution,
+		attachments:       attachments,
+		replyChainDepth:   replyChainDepth,
+		threads:           threads,
+		profiles:          profiles,
+		actionMode:        actionMode,
+		actions:           DefaultActionRegistry(),
+		actionPolicy:      newActionPolicy(policy),
+		attachmentFetcher: newAttachmentFetcher(),
+		ambient:           ambient,
+		ambientGate:       newAmbientGate(ambient, openwebuiClient),
+		overrides:         make(map[string]*channelOverride),
 	}
 }
 
+// Actions returns the registry of actions the model can ask for, so more can be registered
+func (h *OpenWebUIHandler) Actions() *ActionRegistry {
+	return h.actions
+}
+
 // HandleMessage processes a Discord message with OpenWebUI
 func (h *OpenWebUIHandler) HandleMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
-	// Clean up the message content (remove mentions, etc.)
-	content := cleanMessage(s, m.Content)
+	h.HandleMessages(s, []*discordgo.MessageCreate{m})
+}
 
+// HandleMessages answers a burst of consecutive messages from one conversation with a
+// single completion, replying to the last message addressed to the bot
+func (h *OpenWebUIHandler) HandleMessages(s *discordgo.Session, messages []*discordgo.MessageCreate) {
 	// Skip empty messages
-	if strings.TrimSpace(content) == "" {
+	var burst []*discordgo.MessageCreate
+	for _, message := range messages {
+		if strings.TrimSpace(cleanMessage(s, message.Content)) != "" || len(message.Attachments) > 0 {
+			burst = append(burst, message)
+		}
+	}
+	if len(burst) == 0 {
 		return
 	}
 
-	// Check if this is a direct mention or command
-	isMention := false
-	for _, mention := range m.Mentions {
-		if mention.ID == s.State.User.ID {
-			isMention = true
-			break
-		}
+	// Direct message conversations are kept per user so they never mix with channel contexts
+	first := burst[0]
+	isDirect := isDirectMessage(first)
+	contextKey := first.ChannelID
+	if isDirect {
+		contextKey = contextmgr.DirectMessageKey(first.Author.ID)
 	}
-	isCommand := strings.HasPrefix(m.Content, h.discordClient.GetCommandPrefix())
 
-	// Check if the bot was recently mentioned or commanded (within ~20 minutes)
-	wasRecentlyActive := h.contextManager.WasRecentlyMentionedOrCommanded(m.ChannelID, 20)
+	// Work out the model, prompt and behaviour for this conversation
+	profile := h.resolveProfile(s, first.GuildID, first.ChannelID, isDirect)
 
-	// Send the response if it's a direct mention/command, was recently active, or if the response seems appropriate
-	if !isMention && !isCommand && !wasRecentlyActive {
-		return
+	// Answer the last message addressed to the bot
+	var m *discordgo.MessageCreate
+	isMention, isAddressed := false, false
+	for _, message := range burst {
+		if mention, addressed := h.addressing(s, message); addressed {
+			m, isMention, isAddressed = message, mention, true
+		}
 	}
 
-	// Set typing indicator
-	if isMention || isCommand {
-		if err := h.discordClient.SetTyping(m.ChannelID); err != nil {
-			logger.Warn("Failed to set typing indicator", zap.Error(err))
+	// Otherwise consider answering the last message while the bot is engaged in the
+	// conversation, when the profile follows conversations
+	policy := h.engagementPolicy(profile)
+	unsolicited := false
+	if m == nil {
+		if !profile.Ambient || h.contextManager.GetEngagement(contextKey, policy).State == contextmgr.EngagementIdle {
+			return
 		}
-	}
+		m = burst[len(burst)-1]
+
+		// Check the reply budget and chance, then ask the ambient gate before paying for
+		// the main model
+		respond, reason := h.contextManager.AllowUnsolicited(contextKey, policy)
+		if respond {
+			decision := h.ambientGate.Decide(s, burst, h.contextManager.GetMessages(contextKey))
+			logger.Debug("Ambient decision",
+				zap.String("channel_id", m.ChannelID),
+				zap.Float64("score", decision.Score),
+				zap.Bool("respond", decision.Respond),
+				zap.String("source", decision.Source),
+				zap.Strings("reasons", decision.Reasons),
+			)
 
-	// Log the incoming message
-	logger.Info("Received Discord message",
-		zap.String("user", m.Author.Username),
-		zap.String("channel_id", m.ChannelID),
-		zap.Int("content_length", len(content)),
-	)
+			respond = decision.Respond
+			if !respond {
+				h.contextManager.RecordUnsolicited(contextKey, policy, false)
+			}
+		} else {
+			logger.Debug("Skipping ambient message",
+				zap.String("channel_id", m.ChannelID),
+				zap.String("reason", reason),
+			)
+		}
 
-	// Add user message to context with username
-	h.contextManager.AddMessage(m.ChannelID, "user", content, m.Author.Username)
+		if !respond {
+			// Keep following the conversation without answering it
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			defer cancel()
 
-	// Prepare messages for OpenWebUI
-	messages := h.prepareMessages(m.ChannelID)
+			for _, message := range burst {
+				h.addUserMessage(ctx, s, message, contextKey, message.ChannelID)
+			}
+			return
+		}
+		unsolicited = true
+	}
 
 	// Create a context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
-	// Get completion from OpenWebUI with retries
-	response, err := h.openwebui.WithRetry(ctx, messages, 3)
-	if err != nil {
-		logger.Error("Failed to get completion from OpenWebUI",
-			zap.Error(err),
-			zap.String("channel_id", m.ChannelID),
-		)
-		h.discordClient.SendMessage(m.ChannelID, "Sorry, I encountered an error while processing your message. Please try again later.")
-		return
+	// The conversation happens in the message's channel unless a thread is started for it
+	channelID := m.ChannelID
+	replyToID := m.ID
+	if isMention && h.threads.startsThread(m.ChannelID) {
+		name := threadName(resolveInboundMentions(s, m.GuildID, cleanMessage(s, m.Content)), displayName(s, m.GuildID, m.Member, m.Author))
+		threadID, err := h.discordClient.StartThread(ctx, m.ChannelID, m.ID, name, h.threads.AutoArchiveMinutes)
+		if err != nil {
+			logger.Warn("Failed to start conversation thread, answering in the channel", zap.Error(err))
+		} else {
+			// The thread starts from the message, so there's nothing to reply to inside it
+			channelID = threadID
+			contextKey = threadID
+			replyToID = ""
+		}
 	}
 
-	// Parse actions from the response
-	actions, cleanResponse := ParseActions(response)
+	// Being addressed starts a new engagement window for the conversation
+	if isAddressed {
+		h.contextManager.Engage(contextKey)
+	}
 
-	// Execute actions using the original message ID (m.ID)
-	ExecuteActions(s, m.ChannelID, m.ID, actions)
+	// Track the generation so it can be stopped from Discord
+	inFlight := InFlightRequest{
+		ChannelID: channelID,
+		MessageID: m.ID,
+		UserID:    m.Author.ID,
+	}
+	if channelID != m.ChannelID {
+		inFlight.ParentChannelID = m.ChannelID
+	}
+	genCtx, generation, finishGeneration := h.discordClient.inFlight.start(ctx, inFlight)
+	defer finishGeneration()
 
-	// Add assistant response to context (using the cleaned response)
-	h.contextManager.AddMessage(m.ChannelID, "assistant", cleanResponse, "")
+	// Set typing indicator
+	if isAddressed {
+		if err := h.discordClient.SetTyping(channelID); err != nil {
+			logger.Warn("Failed to set typing indicator", zap.Error(err))
+		}
+	}
 
-	// Check for format action
-	var formattedResponse string = cleanResponse
-	var shouldPin bool = false
+	// Add every message in the burst to the context as one turn
+	for _, message := range burst {
+		h.addUserMessage(ctx, s, message, contextKey, channelID)
+	}
+	if len(burst) > 1 {
+		logger.Info("Coalesced Discord messages into one turn",
+			zap.String("channel_id", channelID),
+			zap.Int("messages", len(burst)),
+		)
+	}
 
-	// set to an empty response if the Silence action is in use.
-	hasSilenceAction := false
-	for _, action := range actions {
-		if action.Type == ActionSilence {
-			hasSilenceAction = true
-			break
+	// Stream the response into progressively edited messages when enabled
+	var stream *streamingMessage
+	var onProgress func(partial string)
+	var streamed string
+	if h.streamResponses {
+		stream = newStreamingMessage(h.discordClient, channelID, replyToID).WithComponents(stopButton(m.ID))
+
+		// Ambient replies only appear once there's something to show
+		if isAddressed {
+			if err := stream.Placeholder(ctx); err != nil {
+				logger.Warn("Failed to send placeholder message", zap.Error(err))
+			}
 		}
+
+		onProgress = func(partial string) {
+			// Partial text skips mention resolution, but must not ping everyone either
+			streamed = defuseMassMentions(StripActions(partial))
+			if err := stream.Update(ctx, streamed); err != nil {
+				logger.Warn("Failed to update streamed response", zap.Error(err))
+			}
+		}
+	}
+
+	// Actions run against the message being answered once the policy allows them
+	request := actionRequest{GuildID: m.GuildID, UserID: m.Author.ID, Member: m.Member, Profile: profile}
+	preSend := &ActionContext{Session: s, ChannelID: m.ChannelID, MessageID: m.ID}
+	runActions := func(action Action) actionDecision {
+		if action.handler.Phase() != PhasePreSend {
+			return h.deferAction(request, preSend, action)
+		}
+		return h.applyAction(request, preSend, action)
 	}
 
-	if hasSilenceAction {
-		formattedResponse = ""
-	}
-
-	for _, action := range actions {
-		if action.Type == ActionFormat {
-			// Parse format action: format|type:language|content
-			parts := strings.SplitN(action.Parameters, "|", 2)
-			if len(parts) >= 2 {
-				formatType := parts[0]
-				formatContent := parts[1]
-
-				switch formatType {
-				case "code":
-					// Format as code block
-					langParts := strings.SplitN(formatContent, "|", 2)
-					if len(langParts) >= 2 {
-						language := langParts[0]
-						code := langParts[1]
-						formattedResponse = "```" + language + "\n" + code + "\n```"
-					}
-				case "bold":
-					formattedResponse = "**" + formatContent + "**"
-				case "italic":
-					formattedResponse = "*" + formatContent + "*"
-				case "quote":
-					lines := strings.Split(formatContent, "\n")
-					var quotedLines []string
-					for _, line := range lines {
-						quotedLines = append(quotedLines, "> "+line)
-					}
-					formattedResponse = strings.Join(quotedLines, "\n")
-				}
-
-				logger.Debug("Applied formatting", zap.String("type", formatType))
+	// Get completion from OpenWebUI with retries
+	result, err := h.generateResponse(genCtx, contextKey, profile, onProgress, runActions)
+	stoppedBy, stopped := h.discordClient.inFlight.stopper(generation)
+	finishGeneration()
+
+	// A stopped or timed out generation is never a complete reply, whatever was returned
+	if err == nil && genCtx.Err() != nil {
+		err = genCtx.Err()
+	}
+	if err != nil || stopped {
+		// The request's deadline may have passed, so the notice gets its own
+		noticeCtx, cancelNotice := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancelNotice()
+
+		// Keep whatever was streamed before the generation was stopped, with a note
+		if stopped {
+			logger.Info("Generation stopped",
+				zap.String("channel_id", channelID),
+				zap.String("stopped_by", stoppedBy),
+			)
+
+			note := stoppedNote(stoppedBy)
+			if stream != nil {
+				stream.Finish(noticeCtx, strings.TrimSpace(streamed+"\n\n"+note))
+			} else {
+				h.discordClient.SendReply(noticeCtx, channelID, replyToID, note)
 			}
-		} else if action.Type == ActionPin {
-			shouldPin = true
+			return
+		}
+
+		logger.Error("Failed to get completion from OpenWebUI",
+			zap.Error(err),
+			zap.String("channel_id", channelID),
+		)
+
+		if stream != nil {
+			stream.Finish(noticeCtx, "")
 		}
+		h.discordClient.SendReply(noticeCtx, channelID, replyToID, "Sorry, I encountered an error while processing your message. Please try again later.")
+		return
 	}
 
+	// Run the actions that act on the original message (m.ID)
+	h.applyActions(request, preSend, result.Actions, PhasePreSend)
+
+	// Let actions such as silence and format rewrite the response
+	content := &ActionContext{Session: s, ChannelID: channelID, MessageID: m.ID, Content: result.CleanResponse}
+	h.applyActions(request, content, result.Actions, PhaseReplaceContent)
+	formattedResponse := resolveOutboundMentions(s, m.GuildID, content.Content)
+
 	// Only send a response if there's actual content to send
 	var sentMsg string
-	if strings.TrimSpace(formattedResponse) != "" {
+	if stream != nil {
+		// Replace the streamed text with the final formatted response
+		if err := stream.Finish(ctx, strings.TrimSpace(formattedResponse)); err != nil {
+			logger.Error("Failed to finish streamed response",
+				zap.Error(err),
+				zap.String("channel_id", channelID),
+			)
+		}
+
+		sentMsg = stream.LastMessageID()
+		if sentMsg == "" {
+			logger.Info("No response content to send",
+				zap.String("channel_id", channelID),
+			)
+		}
+	} else if strings.TrimSpace(formattedResponse) != "" {
 		// Send the response if it's a direct mention/command, was recently active, or if the response seems appropriate
-		sentMsg, err = h.discordClient.SendMessage(m.ChannelID, formattedResponse)
+		sentMsg, err = h.discordClient.SendReply(ctx, channelID, replyToID, formattedResponse)
 		if err != nil {
 			logger.Error("Failed to send response to Discord",
 				zap.Error(err),
-				zap.String("channel_id", m.ChannelID),
+				zap.String("channel_id", channelID),
 			)
 		}
 	} else {
 		// Log that there's no response content
 		logger.Info("No response content to send",
-			zap.String("channel_id", m.ChannelID),
+			zap.String("channel_id", channelID),
 		)
 	}
 
-	// Handle pin action if needed
-	if shouldPin && sentMsg != "" {
-		err := s.ChannelMessagePin(m.ChannelID, sentMsg)
-		if err != nil {
-			logger.Warn("Failed to pin message", zap.Error(err), zap.String("message_id", sentMsg))
-		} else {
-			logger.Info("Pinned message", zap.String("message_id", sentMsg))
+	// Run actions that act on the sent reply, such as pinning it
+	if sentMsg != "" {
+		h.applyActions(request, &ActionContext{Session: s, ChannelID: channelID, MessageID: m.ID, ReplyID: sentMsg}, result.Actions, PhasePostSend)
+	}
+
+	// Count unsolicited replies and silences against the engagement
+	if unsolicited {
+		h.contextManager.RecordUnsolicited(contextKey, policy, sentMsg != "")
+	}
+
+	h.logResponseSent(contextKey, result)
+}
+
+// addressing reports whether a message mentions the bot, and whether it is addressed to
+// the bot at all
+func (h *OpenWebUIHandler) addressing(s *discordgo.Session, m *discordgo.MessageCreate) (isMention, isAddressed bool) {
+	// Check if this is a direct mention or command
+	for _, mention := range m.Mentions {
+		if mention.ID == s.State.User.ID {
+			isMention = true
+			break
 		}
 	}
+	isCommand := strings.HasPrefix(m.Content, h.discordClient.GetCommandPrefix())
+
+	// Direct messages and messages in threads the bot started are always addressed to it
+	isDirect := isDirectMessage(m)
+	inOwnThread := !isDirect && isOwnThread(s, m.ChannelID)
 
-	logger.Info("Sent response to Discord",
-		zap.String("channel_id", m.ChannelID),
-		zap.Int("response_length", len(cleanResponse)),
-		zap.Int("context_size", h.contextManager.GetContextSize(m.ChannelID)),
+	return isMention, isMention || isCommand || isDirect || inOwnThread
+}
+
+// addUserMessage adds a Discord message to the conversation context with its author's
+// display name, attachments and the messages it replies to
+func (h *OpenWebUIHandler) addUserMessage(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, contextKey, channelID string) {
+	// Clean up the message content and replace mention tokens with names the model can read
+	content := resolveInboundMentions(s, m.GuildID, cleanMessage(s, m.Content))
+	speaker := displayName(s, m.GuildID, m.Member, m.Author)
+
+	// Log the incoming message
+	logger.Info("Received Discord message",
+		zap.String("user", m.Author.Username),
+		zap.String("channel_id", channelID),
+		zap.Int("content_length", len(content)),
+		zap.Int("attachments", len(m.Attachments)),
 	)
+
+	// Inline text attachments into the turn and keep references to images
+	ingested, images := h.ingestAttachments(ctx, m.Attachments)
+	if ingested != "" {
+		content = strings.TrimSpace(content + "\n\n" + ingested)
+	}
+
+	// Quote the messages being replied to, which may have aged out of the context
+	if m.MessageReference != nil && h.replyChainDepth > 0 {
+		if quoted := quoteReplyChain(s, m.GuildID, replyChain(s, m.Message, h.replyChainDepth)); quoted != "" {
+			content = quoted + "\n" + content
+		}
+	}
+
+	// Add user message to context with the author's display name
+	h.contextManager.AddMessageWithAttachments(contextKey, "user", content, speaker, images)
 }
 
-// prepareMessages prepares the messages for the OpenWebUI API
-func (h *OpenWebUIHandler) prepareMessages(channelID string) []openwebui.Message {
+// completion is a generated response along with the details needed to act on and log it
+type completion struct {
+	Actions         []Action
+	CleanResponse   string
+	EstimatedTokens int
+	// Usage adds up every request made for the reply, and FirstPromptTokens is the prompt
+	// usage of the first one, which is what EstimatedTokens estimates
+	Usage             *openwebui.Usage
+	FirstPromptTokens int
+}
+
+// generateResponse sends the channel's context to OpenWebUI, records the reply in the
+// context and returns the actions along with the cleaned response text.
+// When onProgress is set the response is streamed and onProgress receives the text so far.
+// In tool mode runActions carries out the actions the model calls while generating, and
+// only the actions that apply to the reply are returned.
+func (h *OpenWebUIHandler) generateResponse(ctx context.Context, channelID string, profile *effectiveProfile, onProgress func(partial string), runActions actionRunner) (*completion, error) {
+	client := h.clientFor(profile)
+
+	// Prepare messages for OpenWebUI
+	messages, estimatedTokens := h.prepareMessages(ctx, channelID, profile.SystemPrompt, client.TokenBudget(), profile.Vision)
+
+	// Get completion from OpenWebUI with retries, parsing action markup from the fully
+	// assembled response unless the model calls actions as tools
+	var actions []Action
+	var cleanResponse string
+	var usage *openwebui.Usage
+	var firstPromptTokens int
+	if profile.ActionMode == ActionModeTools && len(h.actions.Tools(profile.actionEnabled)) > 0 {
+		reply, deferred, err := h.completeWithTools(ctx, client, messages, profile, onProgress, runActions)
+		if err != nil {
+			return nil, err
+		}
+		actions, cleanResponse, usage = deferred, strings.TrimSpace(reply.Content), reply.Usage
+		firstPromptTokens = reply.FirstPromptTokens
+	} else {
+		reply, err := complete(ctx, client, messages, onProgress)
+		if err != nil {
+			return nil, err
+		}
+		var markupErrors []*MarkupError
+		actions, cleanResponse, markupErrors = h.actions.Parse(reply.Content)
+		for _, markupErr := range markupErrors {
+			logger.Warn("Malformed action markup in response",
+				zap.String("channel_id", channelID),
+				zap.Int("line", markupErr.Line),
+				zap.Int("column", markupErr.Column),
+				zap.String("error", markupErr.Message),
+			)
+		}
+		usage = reply.Usage
+		if usage != nil {
+			firstPromptTokens = usage.PromptTokens
+		}
+	}
+
+	// Add assistant response to context (using the cleaned response)
+	h.contextManager.AddMessage(channelID, "assistant", cleanResponse, "")
+
+	return &completion{
+		Actions:           actions,
+		CleanResponse:     cleanResponse,
+		EstimatedTokens:   estimatedTokens,
+		Usage:             usage,
+		FirstPromptTokens: firstPromptTokens,
+	}, nil
+}
+
+// modelReply is the content and tool calls of a single completion
+type modelReply struct {
+	Content   string
+	ToolCalls []openwebui.ToolCall
+	Usage     *openwebui.Usage
+	// FirstPromptTokens is the prompt usage of the first of several tool rounds
+	FirstPromptTokens int
+}
+
+// complete gets a single completion, streamed when onProgress is set
+func complete(ctx context.Context, client *openwebui.Client, messages []openwebui.Message, onProgress func(partial string)) (*modelReply, error) {
+	reply, err := completeOnce(ctx, client, messages, onProgress)
+	if err == nil || !openwebui.IsRequestRejected(err) {
+		return reply, err
+	}
+
+	// Models without vision reject image parts, so try again with notes in their place
+	if stripped, ok := withoutImages(messages); ok {
+		logger.Warn("Request with images was rejected, retrying without them", zap.Error(err))
+		return completeOnce(ctx, client, stripped, onProgress)
+	}
+
+	return nil, err
+}
+
+// completeOnce gets a completion, streaming it when onProgress is set
+func completeOnce(ctx context.Context, client *openwebui.Client, messages []openwebui.Message, onProgress func(partial string)) (*modelReply, error) {
+	if onProgress != nil {
+		return streamCompletion(ctx, client, messages, onProgress)
+	}
+
+	return retryCompletion(ctx, client, messages)
+}
+
+// retryCompletion gets a full completion with retries
+func retryCompletion(ctx context.Context, client *openwebui.Client, messages []openwebui.Message) (*modelReply, error) {
+	resp, err := client.ChatCompletionWithRetry(ctx, messages, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	message := resp.Choices[0].Message
+	return &modelReply{
+		Content:   message.Content,
+		ToolCalls: message.ToolCalls,
+		Usage:     &resp.Usage,
+	}, nil
+}
+
+// streamCompletion streams a completion, falling back to a regular request with retries
+// if the stream fails before producing any content
+func streamCompletion(ctx context.Context, client *openwebui.Client, messages []openwebui.Message, onProgress func(partial string)) (*modelReply, error) {
+	deltas, err := client.ChatCompletionStream(ctx, messages)
+	if err != nil {
+		logger.Warn("Failed to start streaming completion, falling back to full completion", zap.Error(err))
+		return retryCompletion(ctx, client, messages)
+	}
+
+	var sb strings.Builder
+	reply := &modelReply{}
+	for delta := range deltas {
+		if delta.Err != nil {
+			// Don't fall back when the request itself was cancelled or timed out
+			if sb.Len() == 0 && ctx.Err() == nil {
+				logger.Warn("Streaming completion failed, falling back to full completion", zap.Error(delta.Err))
+				return retryCompletion(ctx, client, messages)
+			}
+			return nil, delta.Err
+		}
+
+		if delta.Usage != nil {
+			reply.Usage = delta.Usage
+			continue
+		}
+		if len(delta.ToolCalls) > 0 {
+			reply.ToolCalls = append(reply.ToolCalls, delta.ToolCalls...)
+			continue
+		}
+
+		sb.WriteString(delta.Content)
+		onProgress(sb.String())
+	}
+
+	// A cancelled request never counts as a complete reply
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	reply.Content = sb.String()
+	return reply, nil
+}
+
+// logResponseSent logs a delivered response, comparing the prompt token estimate with the
+// usage reported by the API when it is available
+func (h *OpenWebUIHandler) logResponseSent(channelID string, result *completion) {
+	fields := []zap.Field{
+		zap.String("channel_id", channelID),
+		zap.Int("response_length", len(result.CleanResponse)),
+		zap.Int("context_size", h.contextManager.GetContextSize(channelID)),
+		zap.Int("estimated_prompt_tokens", result.EstimatedTokens),
+	}
+
+	// Tool rounds send longer prompts, so only the first is compared with the estimate
+	if result.FirstPromptTokens > 0 {
+		fields = append(fields,
+			zap.Int("prompt_tokens", result.FirstPromptTokens),
+			zap.Int("prompt_token_estimate_error", result.EstimatedTokens-result.FirstPromptTokens),
+		)
+	}
+	if result.Usage != nil && result.Usage.PromptTokens != result.FirstPromptTokens {
+		fields = append(fields, zap.Int("total_prompt_tokens", result.Usage.PromptTokens))
+	}
+
+	logger.Info("Sent response to Discord", fields...)
+}
+
+// updateOverride applies a change to a channel's overrides, dropping the entry once it is empty
+func (h *OpenWebUIHandler) updateOverride(channelID string, update func(o *channelOverride)) {
+	h.overridesMutex.Lock()
+	defer h.overridesMutex.Unlock()
+
+	override, exists := h.overrides[channelID]
+	if !exists {
+		override = &channelOverride{}
+		h.overrides[channelID] = override
+	}
+
+	update(override)
+
+	if override.Model == "" && override.Persona == "" {
+		delete(h.overrides, channelID)
+	}
+}
+
+// prepareMessages prepares the messages for the OpenWebUI API, dropping or truncating the
+// oldest context messages to fit the token budget, and returns the estimated prompt tokens
+func (h *OpenWebUIHandler) prepareMessages(ctx context.Context, channelID, systemPrompt string, tokenBudget int, vision bool) ([]openwebui.Message, int) {
 	// Get messages from context
 	contextMessages := h.contextManager.GetMessages(channelID)
 
-	// Create messages array with system prompt
-	messages := []openwebui.Message{
+	// The system prompt and summary are always sent
+	pinned := []openwebui.Message{
 		{
 			Role:    "system",
-			Content: h.systemPrompt,
-			// Content: h.systemPrompt,
+			Content: systemPrompt,
 		},
 	}
 
-	// Add context messages
-	for _, msg := range contextMessages {
-		messages = append(messages, openwebui.Message{
-			Role:    msg.Role,
-			Content: msg.Content,
+	// Include the running summary of history that has aged out of the context
+	if summary := h.contextManager.GetSummary(channelID); summary != "" {
+		pinned = append(pinned, openwebui.Message{
+			Role:    "system",
+			Content: "Summary of the earlier conversation in this channel:\n" + summary,
 		})
 	}
 
-	return messages
+	// Add context messages
+	history := make([]openwebui.Message, len(contextMessages))
+	for i, msg := range contextMessages {
+		history[i] = attributeMessage(msg, h.attribution)
+	}
+
+	// Attach images newest first so the most recent ones win the per-request allowance,
+	// or describe them to models without vision
+	remaining := h.attachments.MaxImages
+	for i := len(contextMessages) - 1; i >= 0; i-- {
+		if len(contextMessages[i].Attachments) == 0 {
+			continue
+		}
+
+		note := imageNotes(contextMessages[i].Attachments)
+		if vision {
+			history[i].Parts, note = h.imageParts(ctx, contextMessages[i].Attachments, &remaining)
+		}
+		if note != "" {
+			history[i].Content = strings.TrimSpace(history[i].Content + "\n" + note)
+		}
+	}
+
+	messages, estimatedTokens := openwebui.FitToBudget(pinned, history, tokenBudget)
+	if dropped := len(pinned) + len(history) - len(messages); dropped > 0 {
+		logger.Debug("Dropped context messages to fit token budget",
+			zap.String("channel_id", channelID),
+			zap.Int("dropped", dropped),
+			zap.Int("token_budget", tokenBudget),
+		)
+	}
+
+	return messages, estimatedTokens
 }
 
 // cleanMessage removes bot mentions and cleans up the message content
@@ -0,0 +1,326 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"go.uber.org/zap"
+)
+
+// manageChannelsPermission restricts configuration commands to members who can manage channels
+var manageChannelsPermission int64 = discordgo.PermissionManageChannels
+
+// Commands returns the slash commands served by the OpenWebUI handler
+func (h *OpenWebUIHandler) Commands() []*Command {
+	return []*Command{
+		{
+			Definition: &discordgo.ApplicationCommand{
+				Name:        "ask",
+				Description: "Ask the bot a question",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "question",
+						Description: "What you want to ask",
+						Required:    true,
+					},
+				},
+			},
+			Handler: h.handleAskCommand,
+		},
+		{
+			Definition: &discordgo.ApplicationCommand{
+				Name:                     "reset",
+				Description:              "Forget the conversation in this channel",
+				DefaultMemberPermissions: &manageChannelsPermission,
+			},
+			Handler: h.handleResetCommand,
+			// The context is shared by everyone in the channel
+			Permission: PermissionAdmin,
+		},
+		{
+			Definition: &discordgo.ApplicationCommand{
+				Name:                     "model",
+				Description:              "Show or change the model used in this channel",
+				DefaultMemberPermissions: &manageChannelsPermission,
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "name",
+						Description: "Model to use in this channel",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "clear",
+						Description: "Go back to the default model",
+					},
+				},
+			},
+			Handler:    h.handleModelCommand,
+			Permission: PermissionAdmin,
+		},
+		{
+			Definition: &discordgo.ApplicationCommand{
+				Name:                     "persona",
+				Description:              "Show or change the bot's persona in this channel",
+				DefaultMemberPermissions: &manageChannelsPermission,
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "prompt",
+						Description: "Persona description used as the system prompt",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "clear",
+						Description: "Go back to the default persona",
+					},
+				},
+			},
+			Handler:    h.handlePersonaCommand,
+			Permission: PermissionAdmin,
+		},
+		{
+			Definition: &discordgo.ApplicationCommand{
+				Name:                     "profile",
+				Description:              "Show the settings the bot uses in this channel and where they come from",
+				DefaultMemberPermissions: &manageChannelsPermission,
+			},
+			Handler:    h.handleProfileCommand,
+			Permission: PermissionAdmin,
+		},
+		{
+			Definition: &discordgo.ApplicationCommand{
+				Name:                     "engagement",
+				Description:              "Show whether the bot is following the conversation in this channel",
+				DefaultMemberPermissions: &manageChannelsPermission,
+			},
+			Handler:    h.handleEngagementCommand,
+			Permission: PermissionAdmin,
+		},
+		{
+			Definition: &discordgo.ApplicationCommand{
+				Name:                     "actions",
+				Description:              "Show the actions the bot was recently asked to take in this channel",
+				DefaultMemberPermissions: &manageChannelsPermission,
+			},
+			Handler:    h.handleActionsCommand,
+			Permission: PermissionAdmin,
+		},
+	}
+}
+
+// handleAskCommand answers a question asked through /ask
+func (h *OpenWebUIHandler) handleAskCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	option, ok := commandOption(i, "question")
+	if !ok || strings.TrimSpace(option.StringValue()) == "" {
+		respondEphemeral(s, i, "Please provide a question.")
+		return
+	}
+	question := resolveInboundMentions(s, i.GuildID, strings.TrimSpace(option.StringValue()))
+
+	// Acknowledge the interaction while the completion is generated
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		logger.Warn("Failed to defer interaction response", zap.Error(err), zap.String("channel_id", i.ChannelID))
+		return
+	}
+
+	user := interactionUser(i)
+	logger.Info("Received Discord slash command",
+		zap.String("command", "ask"),
+		zap.String("user", user.Username),
+		zap.String("channel_id", i.ChannelID),
+		zap.Int("content_length", len(question)),
+	)
+
+	// Questions are queued behind the channel's messages so the context is only changed
+	// by one conversation turn at a time
+	ahead, queued := h.discordClient.queue.Enqueue(i.ChannelID, func() {
+		h.answerAskCommand(s, i, user, question)
+	})
+	if !queued {
+		editInteractionResponse(s, i, "I'm too busy to take more questions here right now. Please try again in a moment.")
+		return
+	}
+	if ahead > 0 {
+		editInteractionResponse(s, i, queuePositionMessage(ahead))
+	}
+}
+
+// answerAskCommand generates and sends the answer to a question asked through /ask
+func (h *OpenWebUIHandler) answerAskCommand(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, question string) {
+	// Add user message to context with the invoker's display name
+	h.contextManager.AddMessage(i.ChannelID, "user", question, displayName(s, i.GuildID, i.Member, user))
+
+	// Create a context with timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	// Track the generation so it can be stopped with the stop command
+	genCtx, generation, finishGeneration := h.discordClient.inFlight.start(ctx, InFlightRequest{
+		ChannelID: i.ChannelID,
+		UserID:    user.ID,
+	})
+	defer finishGeneration()
+
+	// Actions the model calls while generating wait until the answer is ready
+	profile := h.resolveProfile(s, i.GuildID, i.ChannelID, false)
+	request := actionRequest{GuildID: i.GuildID, UserID: user.ID, Member: i.Member, Profile: profile}
+	runActions := func(action Action) actionDecision {
+		return h.deferAction(request, &ActionContext{Session: s, ChannelID: i.ChannelID}, action)
+	}
+
+	result, err := h.generateResponse(genCtx, i.ChannelID, profile, nil, runActions)
+	stoppedBy, stopped := h.discordClient.inFlight.stopper(generation)
+	finishGeneration()
+	if stopped {
+		logger.Info("Generation stopped",
+			zap.String("channel_id", i.ChannelID),
+			zap.String("stopped_by", stoppedBy),
+		)
+		editInteractionResponse(s, i, stoppedNote(stoppedBy))
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to get completion from OpenWebUI",
+			zap.Error(err),
+			zap.String("channel_id", i.ChannelID),
+		)
+		editInteractionResponse(s, i, "Sorry, I encountered an error while processing your message. Please try again later.")
+		return
+	}
+
+	// Slash commands have no message to act on, so actions such as react are skipped
+	actx := &ActionContext{Session: s, ChannelID: i.ChannelID}
+	h.applyActions(request, actx, result.Actions, PhasePreSend)
+
+	// Let actions such as silence and format rewrite the answer
+	content := &ActionContext{Session: s, ChannelID: i.ChannelID, Content: result.CleanResponse}
+	h.applyActions(request, content, result.Actions, PhaseReplaceContent)
+
+	// The question was asked explicitly, so an empty answer still needs a reply
+	formattedResponse := content.Content
+	if strings.TrimSpace(formattedResponse) == "" {
+		formattedResponse = "🤐"
+	}
+	formattedResponse = resolveOutboundMentions(s, i.GuildID, formattedResponse)
+
+	parts := splitMessage(formattedResponse, 1900)
+	msg := editInteractionResponse(s, i, parts[0])
+	for _, part := range parts[1:] {
+		if _, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{Content: part, AllowedMentions: allowedMentions()}); err != nil {
+			logger.Error("Failed to send follow-up message", zap.Error(err), zap.String("channel_id", i.ChannelID))
+			break
+		}
+	}
+
+	if msg == nil {
+		return
+	}
+
+	// Run actions that act on the answer, such as pinning it
+	actx.ReplyID = msg.ID
+	h.applyActions(request, actx, result.Actions, PhasePostSend)
+
+	h.logResponseSent(i.ChannelID, result)
+}
+
+// handleResetCommand clears the conversation context for the channel
+func (h *OpenWebUIHandler) handleResetCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h.contextManager.ClearChannel(i.ChannelID)
+	respondEphemeral(s, i, "Conversation context cleared for this channel.")
+}
+
+// handleModelCommand shows or changes the model used in the channel
+func (h *OpenWebUIHandler) handleModelCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if option, ok := commandOption(i, "clear"); ok && option.BoolValue() {
+		h.updateOverride(i.ChannelID, func(o *channelOverride) { o.Model = "" })
+		respondEphemeral(s, i, fmt.Sprintf("Model reset to `%s`.", h.resolveProfile(s, i.GuildID, i.ChannelID, false).Model))
+		return
+	}
+
+	option, ok := commandOption(i, "name")
+	if !ok || strings.TrimSpace(option.StringValue()) == "" {
+		respondEphemeral(s, i, fmt.Sprintf("This channel is using `%s`.", h.resolveProfile(s, i.GuildID, i.ChannelID, false).Model))
+		return
+	}
+
+	model := strings.TrimSpace(option.StringValue())
+	h.updateOverride(i.ChannelID, func(o *channelOverride) { o.Model = model })
+
+	logger.Info("Changed channel model",
+		zap.String("channel_id", i.ChannelID),
+		zap.String("model", model),
+		zap.String("user_id", interactionUser(i).ID),
+	)
+	respondEphemeral(s, i, fmt.Sprintf("This channel now uses `%s`.", model))
+}
+
+// handlePersonaCommand shows or changes the persona used in the channel
+func (h *OpenWebUIHandler) handlePersonaCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if option, ok := commandOption(i, "clear"); ok && option.BoolValue() {
+		h.updateOverride(i.ChannelID, func(o *channelOverride) { o.Persona = "" })
+		respondEphemeral(s, i, "Persona reset to the default.")
+		return
+	}
+
+	option, ok := commandOption(i, "prompt")
+	if !ok || strings.TrimSpace(option.StringValue()) == "" {
+		h.overridesMutex.RLock()
+		override, exists := h.overrides[i.ChannelID]
+		custom := exists && override.Persona != ""
+		h.overridesMutex.RUnlock()
+
+		if custom {
+			respondEphemeral(s, i, "This channel is using a custom persona. Use `clear` to go back to the default.")
+		} else {
+			respondEphemeral(s, i, "This channel is using the default persona.")
+		}
+		return
+	}
+
+	// The persona is expanded with the action instructions for the channel's action mode
+	persona := strings.TrimSpace(option.StringValue())
+	h.updateOverride(i.ChannelID, func(o *channelOverride) { o.Persona = persona })
+
+	logger.Info("Changed channel persona",
+		zap.String("channel_id", i.ChannelID),
+		zap.Int("prompt_length", len(persona)),
+		zap.String("user_id", interactionUser(i).ID),
+	)
+	respondEphemeral(s, i, "Persona updated for this channel.")
+}
+
+// handleProfileCommand shows the effective profile for the channel
+func (h *OpenWebUIHandler) handleProfileCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	respondEphemeral(s, i, describeProfile(h.resolveProfile(s, i.GuildID, i.ChannelID, false)))
+}
+
+// handleEngagementCommand shows the engagement state for the channel
+func (h *OpenWebUIHandler) handleEngagementCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	policy := h.engagementPolicy(h.resolveProfile(s, i.GuildID, i.ChannelID, false))
+	respondEphemeral(s, i, describeEngagement(h.contextManager.GetEngagement(i.ChannelID, policy), policy)+"\n\n"+describeAmbientStats(h.AmbientStats(), h.ambient))
+}
+
+// handleActionsCommand shows the recent action audit records for the channel
+func (h *OpenWebUIHandler) handleActionsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	respondEphemeral(s, i, describeActionAudit(h.actionPolicy.records(i.ChannelID), 15))
+}
+
+// editInteractionResponse replaces the deferred interaction response with content
+func editInteractionResponse(s *discordgo.Session, i *discordgo.InteractionCreate, content string) *discordgo.Message {
+	msg, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content, AllowedMentions: allowedMentions()})
+	if err != nil {
+		logger.Error("Failed to edit interaction response", zap.Error(err), zap.String("channel_id", i.ChannelID))
+		return nil
+	}
+
+	return msg
+}
@@ -0,0 +1,207 @@
+package discord
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// actionOpener starts action markup: [ACTION:type|parameters]
+const actionOpener = "[ACTION:"
+
+// MarkupError reports malformed action markup at a position in the response
+type MarkupError struct {
+	// Offset is the byte offset of the problem, with Line and Column counted from 1
+	Offset  int
+	Line    int
+	Column  int
+	Message string
+
+	unterminated bool
+}
+
+func (e *MarkupError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// markupAction is an action written as markup in a response
+type markupAction struct {
+	Type       string
+	Parameters string
+	Offset     int
+}
+
+// markupResult is what scanning a response for action markup found
+type markupResult struct {
+	actions []markupAction
+	// clean is the response without its well-formed action markup
+	clean  string
+	errors []*MarkupError
+	// open is where the first unterminated action starts in clean, or -1
+	open int
+}
+
+// scanMarkup tokenizes action markup in a response. Parameters run to the matching closing
+// bracket, so they may span lines and contain balanced brackets; \[, \] and \\ escape a
+// bracket or backslash. Markup inside fenced code blocks is left as text, and malformed
+// markup is kept as text and reported.
+func scanMarkup(content string) markupResult {
+	result := markupResult{open: -1}
+	var clean strings.Builder
+	fence := ""
+	lineStart := true
+
+	for i := 0; i < len(content); {
+		// Fences only open or close at the start of a line
+		if lineStart {
+			if marker := fenceMarker(content[i:]); marker != "" {
+				if fence == "" {
+					fence = marker
+				} else if closesFence(content[i:], marker, fence) {
+					fence = ""
+				}
+			}
+		}
+
+		if fence == "" && strings.HasPrefix(content[i:], actionOpener) {
+			action, end, err := parseAction(content, i)
+			if err == nil {
+				result.actions = append(result.actions, action)
+				i = end
+				lineStart = false
+				continue
+			}
+
+			result.errors = append(result.errors, err)
+			if err.unterminated && result.open < 0 {
+				result.open = clean.Len()
+			}
+
+			// Keep the malformed markup as text and carry on after its opener
+			clean.WriteString(actionOpener)
+			i += len(actionOpener)
+			lineStart = false
+			continue
+		}
+
+		clean.WriteByte(content[i])
+		lineStart = content[i] == '\n'
+		i++
+	}
+
+	result.clean = clean.String()
+	return result
+}
+
+// parseAction parses the action markup starting at start, returning the action and the
+// offset just past it
+func parseAction(content string, start int) (markupAction, int, *MarkupError) {
+	i := start + len(actionOpener)
+	typeStart := i
+	for i < len(content) && isASCIILetter(content[i]) {
+		i++
+	}
+
+	if i >= len(content) {
+		return markupAction{}, 0, markupError(content, start, "unterminated action", true)
+	}
+	if i == typeStart {
+		return markupAction{}, 0, markupError(content, i, "expected an action type", false)
+	}
+	action := markupAction{Type: content[typeStart:i], Offset: start}
+
+	switch content[i] {
+	case ']':
+		return action, i + 1, nil
+	case '|':
+		i++
+	default:
+		r, _ := utf8.DecodeRuneInString(content[i:])
+		return markupAction{}, 0, markupError(content, i, fmt.Sprintf("unexpected %q after action type", r), false)
+	}
+
+	var params strings.Builder
+	depth := 0
+	for i < len(content) {
+		c := content[i]
+		switch {
+		case c == '\\' && i+1 < len(content) && strings.IndexByte(`[]\`, content[i+1]) >= 0:
+			params.WriteByte(content[i+1])
+			i += 2
+			continue
+		case c == '[':
+			depth++
+		case c == ']':
+			if depth == 0 {
+				action.Parameters = params.String()
+				return action, i + 1, nil
+			}
+			depth--
+		}
+
+		params.WriteByte(c)
+		i++
+	}
+
+	return markupAction{}, 0, markupError(content, start, "unterminated action", true)
+}
+
+// fenceMarker returns the run of backticks or tildes that opens a fenced code block at the
+// start of line, or an empty string when the line isn't a fence
+func fenceMarker(line string) string {
+	// Fences may be indented by up to three spaces
+	indent := 0
+	for indent < 3 && indent < len(line) && line[indent] == ' ' {
+		indent++
+	}
+	line = line[indent:]
+
+	if line == "" || (line[0] != '`' && line[0] != '~') {
+		return ""
+	}
+
+	n := 0
+	for n < len(line) && line[n] == line[0] {
+		n++
+	}
+	if n < 3 {
+		return ""
+	}
+
+	return line[:n]
+}
+
+// closesFence reports whether a line starting with marker closes the open fence. Only a
+// bare marker at least as long as the opening one, with optional trailing whitespace,
+// closes it; a line with an info string such as "```go" is content.
+func closesFence(line, marker, fence string) bool {
+	if marker[0] != fence[0] || len(marker) < len(fence) {
+		return false
+	}
+
+	rest := strings.TrimLeft(line, " ")[len(marker):]
+	if end := strings.IndexByte(rest, '\n'); end >= 0 {
+		rest = rest[:end]
+	}
+
+	return strings.TrimRight(rest, " \t\r") == ""
+}
+
+// markupError creates an error for a byte offset, working out its line and column
+func markupError(content string, offset int, message string, unterminated bool) *MarkupError {
+	before := content[:offset]
+	lineStart := strings.LastIndexByte(before, '\n') + 1
+
+	return &MarkupError{
+		Offset:       offset,
+		Line:         strings.Count(before, "\n") + 1,
+		Column:       utf8.RuneCountInString(before[lineStart:]) + 1,
+		Message:      message,
+		unterminated: unterminated,
+	}
+}
+
+// isASCIILetter reports whether c is an ASCII letter
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,206 @@
+package discord
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestScanMarkup(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		actions []markupAction
+		clean   string
+	}{
+		{
+			name:    "plain action",
+			content: "hi [ACTION:react|👍] there",
+			actions: []markupAction{{Type: "react", Parameters: "👍", Offset: 3}},
+			clean:   "hi  there",
+		},
+		{
+			name:    "no parameters",
+			content: "[ACTION:pin]",
+			actions: []markupAction{{Type: "pin", Offset: 0}},
+			clean:   "",
+		},
+		{
+			name:    "escaped brackets and backslash",
+			content: `[ACTION:status|a \[b\] c \\]`,
+			actions: []markupAction{{Type: "status", Parameters: `a [b] c \`, Offset: 0}},
+			clean:   "",
+		},
+		{
+			name:    "balanced nesting",
+			content: "[ACTION:format|code:go|x := m[k][0]]",
+			actions: []markupAction{{Type: "format", Parameters: "code:go|x := m[k][0]", Offset: 0}},
+			clean:   "",
+		},
+		{
+			name:    "multi-line parameters",
+			content: "[ACTION:file|a.txt|line one\nline two]",
+			actions: []markupAction{{Type: "file", Parameters: "a.txt|line one\nline two", Offset: 0}},
+			clean:   "",
+		},
+		{
+			name:    "markup inside a fence is text",
+			content: "```\n[ACTION:pin]\n```",
+			clean:   "```\n[ACTION:pin]\n```",
+		},
+		{
+			name:    "info string line doesn't close a fence",
+			content: "```\n```go\n[ACTION:delete|previous]\n```",
+			clean:   "```\n```go\n[ACTION:delete|previous]\n```",
+		},
+		{
+			name:    "bare marker with trailing whitespace closes a fence",
+			content: "~~~\nx\n~~~  \n[ACTION:pin]",
+			actions: []markupAction{{Type: "pin", Offset: 12}},
+			clean:   "~~~\nx\n~~~  \n",
+		},
+		{
+			name:    "shorter marker doesn't close a fence",
+			content: "````\n```\n[ACTION:pin]\n````",
+			clean:   "````\n```\n[ACTION:pin]\n````",
+		},
+		{
+			name:    "different marker doesn't close a fence",
+			content: "```\n~~~\n[ACTION:pin]",
+			clean:   "```\n~~~\n[ACTION:pin]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := scanMarkup(tt.content)
+			if len(result.errors) > 0 {
+				t.Fatalf("unexpected errors: %v", result.errors)
+			}
+			if result.clean != tt.clean {
+				t.Errorf("clean = %q, want %q", result.clean, tt.clean)
+			}
+			if len(result.actions) != len(tt.actions) {
+				t.Fatalf("actions = %+v, want %+v", result.actions, tt.actions)
+			}
+			for i, action := range result.actions {
+				if action != tt.actions[i] {
+					t.Errorf("action %d = %+v, want %+v", i, action, tt.actions[i])
+				}
+			}
+		})
+	}
+}
+
+func TestScanMarkupErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		line, column int
+		message      string
+		open         int
+	}{
+		{
+			name:    "unterminated",
+			content: "hello [ACTION:react|👍",
+			line:    1, column: 7,
+			message: "unterminated action",
+			open:    6,
+		},
+		{
+			name:    "unterminated type",
+			content: "hello [ACTION:react",
+			line:    1, column: 7,
+			message: "unterminated action",
+			open:    6,
+		},
+		{
+			name:    "missing type",
+			content: "a\nb [ACTION:|x]",
+			line:    2, column: 11,
+			message: "expected an action type",
+			open:    -1,
+		},
+		{
+			name:    "columns count characters",
+			content: "é [ACTION:react!]",
+			line:    1, column: 16,
+			message: `unexpected '!' after action type`,
+			open:    -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := scanMarkup(tt.content)
+			if len(result.errors) != 1 {
+				t.Fatalf("errors = %v, want one", result.errors)
+			}
+
+			err := result.errors[0]
+			if err.Line != tt.line || err.Column != tt.column || err.Message != tt.message {
+				t.Errorf("error = %q, want line %d, column %d: %s", err.Error(), tt.line, tt.column, tt.message)
+			}
+			if result.open != tt.open {
+				t.Errorf("open = %d, want %d", result.open, tt.open)
+			}
+			if len(result.actions) != 0 {
+				t.Errorf("actions = %+v, want none", result.actions)
+			}
+
+			// Malformed markup stays in the response
+			if result.clean != tt.content {
+				t.Errorf("clean = %q, want %q", result.clean, tt.content)
+			}
+		})
+	}
+}
+
+func FuzzScanMarkup(f *testing.F) {
+	f.Add("hi [ACTION:react|👍] there")
+	f.Add(`[ACTION:status|a \[b\] c \\]`)
+	f.Add("[ACTION:format|code:go|x := m[k][0]]")
+	f.Add("[ACTION:file|a.txt|line one\nline two]")
+	f.Add("hello [ACTION:react|👍")
+	f.Add("[ACTION:|x] [ACTION:react!] [ACTION:")
+	f.Add("```\n```go\n[ACTION:delete|previous]\n```\n[ACTION:pin]")
+	f.Add("   ~~~~\n[ACTION:pin]\n~~~~ \t\n[ACTION:silence]")
+
+	f.Fuzz(func(t *testing.T, content string) {
+		result := scanMarkup(content)
+
+		if len(result.clean) > len(content) {
+			t.Fatalf("clean is longer than the content: %q", result.clean)
+		}
+		if result.open > len(result.clean) {
+			t.Fatalf("open %d is past the end of clean %q", result.open, result.clean)
+		}
+		if result.open >= 0 && !strings.HasPrefix(result.clean[result.open:], actionOpener) {
+			t.Fatalf("open %d doesn't point at an opener in %q", result.open, result.clean)
+		}
+
+		for _, action := range result.actions {
+			if action.Type == "" {
+				t.Fatalf("action without a type in %q", content)
+			}
+			if !strings.HasPrefix(content[action.Offset:], actionOpener+action.Type) {
+				t.Fatalf("action %+v doesn't start at its offset in %q", action, content)
+			}
+		}
+
+		for _, err := range result.errors {
+			if err.Offset < 0 || err.Offset > len(content) || err.Line < 1 || err.Column < 1 {
+				t.Fatalf("error out of range: %+v", err)
+			}
+			if err.Line > strings.Count(content, "\n")+1 {
+				t.Fatalf("error line %d past the end of %q", err.Line, content)
+			}
+		}
+
+		// Stripping must never panic or leave an opener that is still arriving
+		stripped := StripActions(content)
+		if utf8.ValidString(content) && !utf8.ValidString(stripped) {
+			t.Fatalf("stripping broke UTF-8: %q", stripped)
+		}
+	})
+}
@@ -0,0 +1,251 @@
+package discord
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"go.uber.org/zap"
+)
+
+var (
+	// mentionTokenRegex matches user, role and channel mention tokens: <@id>, <@!id>, <@&id>, <#id>
+	mentionTokenRegex = regexp.MustCompile(`<(@!?|@&|#)(\d+)>`)
+
+	// emojiTokenRegex matches custom emoji tokens: <:name:id> and <a:name:id>
+	emojiTokenRegex = regexp.MustCompile(`<a?:(\w+):\d+>`)
+
+	// outboundMentionRegex matches @name and #name written by the model. The name must
+	// follow the start of the text, whitespace or an opening bracket so email addresses
+	// and URLs aren't mistaken for mentions.
+	outboundMentionRegex = regexp.MustCompile(`(^|[\s(\[])([@#])([\w.\-]*[A-Za-z][\w.\-]*)`)
+
+	// codeSpanRegex matches fenced code blocks and inline code, which are left untouched
+	codeSpanRegex = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+
+	// massMentionRegex matches @everyone and @here, which the model must never trigger
+	massMentionRegex = regexp.MustCompile(`@(everyone|here)\b`)
+)
+
+// resolveInboundMentions replaces user, role, channel and custom emoji tokens with
+// readable @name, @role, #channel and :emoji: forms
+func resolveInboundMentions(s *discordgo.Session, guildID, content string) string {
+	content = mentionTokenRegex.ReplaceAllStringFunc(content, func(token string) string {
+		match := mentionTokenRegex.FindStringSubmatch(token)
+		kind, id := match[1], match[2]
+
+		switch kind {
+		case "@", "@!":
+			if name := lookupUserName(s, guildID, id); name != "" {
+				return "@" + name
+			}
+		case "@&":
+			if role := lookupRole(s, guildID, id); role != nil {
+				return "@" + role.Name
+			}
+		case "#":
+			if channel := lookupChannel(s, id); channel != nil {
+				return "#" + channel.Name
+			}
+		}
+
+		return token
+	})
+
+	return emojiTokenRegex.ReplaceAllString(content, ":$1:")
+}
+
+// resolveOutboundMentions turns @name, @role and #channel references written by the model
+// into real Discord mentions, leaving code untouched and defusing @everyone and @here
+func resolveOutboundMentions(s *discordgo.Session, guildID, content string) string {
+	if guildID == "" {
+		return defuseMassMentions(content)
+	}
+
+	resolver := &outboundResolver{session: s, guildID: guildID, cache: make(map[string]string)}
+
+	var sb strings.Builder
+	last := 0
+	for _, span := range codeSpanRegex.FindAllStringIndex(content, -1) {
+		sb.WriteString(resolver.resolve(content[last:span[0]]))
+		sb.WriteString(content[span[0]:span[1]])
+		last = span[1]
+	}
+	sb.WriteString(resolver.resolve(content[last:]))
+
+	return sb.String()
+}
+
+// defuseMassMentions inserts a zero-width space so @everyone and @here don't ping anyone
+func defuseMassMentions(content string) string {
+	return massMentionRegex.ReplaceAllString(content, "@\u200b$1")
+}
+
+// outboundResolver resolves names to mention tokens for a single response, caching
+// lookups so repeated names only hit the API once
+type outboundResolver struct {
+	session  *discordgo.Session
+	guildID  string
+	cache    map[string]string
+	channels []*discordgo.Channel
+}
+
+// resolve replaces references in text that contains no code
+func (r *outboundResolver) resolve(text string) string {
+	text = defuseMassMentions(text)
+
+	return outboundMentionRegex.ReplaceAllStringFunc(text, func(match string) string {
+		parts := outboundMentionRegex.FindStringSubmatch(match)
+		prefix, sigil, name := parts[1], parts[2], parts[3]
+
+		// Leave trailing punctuation outside the name
+		trimmed := strings.TrimRight(name, ".-")
+		suffix := name[len(trimmed):]
+
+		key := sigil + strings.ToLower(trimmed)
+		token, cached := r.cache[key]
+		if !cached {
+			if sigil == "#" {
+				token = r.channelToken(trimmed)
+			} else {
+				token = r.memberOrRoleToken(trimmed)
+			}
+			r.cache[key] = token
+		}
+
+		if token == "" {
+			return match
+		}
+
+		return prefix + token + suffix
+	})
+}
+
+// memberOrRoleToken returns the mention token for a member or mentionable role with the given name
+func (r *outboundResolver) memberOrRoleToken(name string) string {
+	if guild, err := r.session.State.Guild(r.guildID); err == nil {
+		for _, member := range guild.Members {
+			if memberMatches(member, name) {
+				return "<@" + member.User.ID + ">"
+			}
+		}
+		for _, role := range guild.Roles {
+			if role.Mentionable && strings.EqualFold(role.Name, name) {
+				return "<@&" + role.ID + ">"
+			}
+		}
+	}
+
+	// Fall back to searching the guild's members through the API
+	members, err := r.session.GuildMembersSearch(r.guildID, name, 5)
+	if err != nil {
+		logger.Debug("Failed to search guild members", zap.String("name", name), zap.Error(err))
+		return ""
+	}
+	for _, member := range members {
+		if memberMatches(member, name) {
+			return "<@" + member.User.ID + ">"
+		}
+	}
+
+	return ""
+}
+
+// channelToken returns the mention token for a guild channel with the given name
+func (r *outboundResolver) channelToken(name string) string {
+	if r.channels == nil {
+		if guild, err := r.session.State.Guild(r.guildID); err == nil && len(guild.Channels) > 0 {
+			r.channels = guild.Channels
+		} else if channels, err := r.session.GuildChannels(r.guildID); err == nil {
+			r.channels = channels
+		} else {
+			logger.Debug("Failed to list guild channels", zap.String("guild_id", r.guildID), zap.Error(err))
+			r.channels = []*discordgo.Channel{}
+		}
+	}
+
+	for _, channel := range r.channels {
+		if strings.EqualFold(channel.Name, name) {
+			return "<#" + channel.ID + ">"
+		}
+	}
+
+	return ""
+}
+
+// memberMatches reports whether a member goes by the given name
+func memberMatches(member *discordgo.Member, name string) bool {
+	if member.User == nil {
+		return false
+	}
+
+	return strings.EqualFold(member.Nick, name) ||
+		strings.EqualFold(member.User.GlobalName, name) ||
+		strings.EqualFold(member.User.Username, name)
+}
+
+// lookupUserName resolves a user ID to their display name using the state cache, then the API
+func lookupUserName(s *discordgo.Session, guildID, userID string) string {
+	if guildID != "" {
+		member, err := s.State.Member(guildID, userID)
+		if err != nil {
+			member, err = s.GuildMember(guildID, userID)
+			if err == nil {
+				// Cache the member so the next lookup doesn't need the API
+				member.GuildID = guildID
+				s.State.MemberAdd(member)
+			}
+		}
+		if err == nil && member.User != nil {
+			return displayName(s, guildID, member, member.User)
+		}
+	}
+
+	user, err := s.User(userID)
+	if err != nil {
+		logger.Debug("Failed to resolve user mention", zap.String("user_id", userID), zap.Error(err))
+		return ""
+	}
+
+	return displayName(s, "", nil, user)
+}
+
+// lookupRole resolves a role ID using the state cache, then the API
+func lookupRole(s *discordgo.Session, guildID, roleID string) *discordgo.Role {
+	if guildID == "" {
+		return nil
+	}
+
+	if role, err := s.State.Role(guildID, roleID); err == nil {
+		return role
+	}
+
+	roles, err := s.GuildRoles(guildID)
+	if err != nil {
+		logger.Debug("Failed to resolve role mention", zap.String("role_id", roleID), zap.Error(err))
+		return nil
+	}
+	for _, role := range roles {
+		if role.ID == roleID {
+			return role
+		}
+	}
+
+	return nil
+}
+
+// lookupChannel resolves a channel ID using the state cache, then the API
+func lookupChannel(s *discordgo.Session, channelID string) *discordgo.Channel {
+	if channel, err := s.State.Channel(channelID); err == nil {
+		return channel
+	}
+
+	channel, err := s.Channel(channelID)
+	if err != nil {
+		logger.Debug("Failed to resolve channel mention", zap.String("channel_id", channelID), zap.Error(err))
+		return nil
+	}
+
+	return channel
+}
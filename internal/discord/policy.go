@@ -0,0 +1,171 @@
+package discord
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Permission is something a user can be allowed or denied by the authorization policy
+type Permission string
+
+const (
+	// PermissionChat allows talking to the bot and using everyday slash commands
+	PermissionChat Permission = "chat"
+	// PermissionAdmin allows slash commands that change how the bot behaves
+	PermissionAdmin Permission = "admin"
+	// PermissionDestructive allows triggering actions that remove or pin messages, change the
+	// bot's status or upload files, and approving action confirmations
+	PermissionDestructive Permission = "destructive"
+)
+
+// PolicyRule allows or denies a permission by user and role ID. Denials win over
+// allowances, and a rule with no allow lists allows everyone who isn't denied.
+type PolicyRule struct {
+	AllowUsers []string
+	AllowRoles []string
+	DenyUsers  []string
+	DenyRoles  []string
+}
+
+// PermissionRules holds the rule for each permission. A nil rule allows everyone.
+type PermissionRules struct {
+	Chat        *PolicyRule
+	Admin       *PolicyRule
+	Destructive *PolicyRule
+}
+
+// rule returns the rule for a permission
+func (r PermissionRules) rule(permission Permission) *PolicyRule {
+	switch permission {
+	case PermissionChat:
+		return r.Chat
+	case PermissionAdmin:
+		return r.Admin
+	case PermissionDestructive:
+		return r.Destructive
+	}
+
+	return nil
+}
+
+// AuthorizationPolicy decides what users may do based on their user and role IDs
+type AuthorizationPolicy struct {
+	// DenyResponse is sent to denied users who address the bot. Empty ignores them silently.
+	DenyResponse string
+	// Default holds the rules used in every guild and in direct messages
+	Default PermissionRules
+	// Guilds replaces default rules for individual guilds, keyed by guild ID
+	Guilds map[string]PermissionRules
+}
+
+// authorizationDecision is the outcome of a policy check along with why it was made
+type authorizationDecision struct {
+	Allowed bool
+	Reason  string
+}
+
+// check decides whether a user has a permission. The member carries the user's roles
+// and is nil in direct messages.
+func (p AuthorizationPolicy) check(guildID, userID string, member *discordgo.Member, permission Permission) authorizationDecision {
+	rule := p.Default.rule(permission)
+	source := "default"
+	if guildRules, exists := p.Guilds[guildID]; exists && guildID != "" {
+		if guildRule := guildRules.rule(permission); guildRule != nil {
+			rule = guildRule
+			source = "guild"
+		}
+	}
+
+	if rule == nil {
+		return authorizationDecision{Allowed: true, Reason: "no " + string(permission) + " rule"}
+	}
+
+	var roles []string
+	if member != nil {
+		roles = member.Roles
+	}
+
+	if containsID(rule.DenyUsers, userID) {
+		return authorizationDecision{Allowed: false, Reason: source + " rule denies user"}
+	}
+	if roleID := firstMatchingID(rule.DenyRoles, roles); roleID != "" {
+		return authorizationDecision{Allowed: false, Reason: source + " rule denies role " + roleID}
+	}
+
+	if len(rule.AllowUsers) == 0 && len(rule.AllowRoles) == 0 {
+		return authorizationDecision{Allowed: true, Reason: source + " rule has no allow list"}
+	}
+	if containsID(rule.AllowUsers, userID) {
+		return authorizationDecision{Allowed: true, Reason: source + " rule allows user"}
+	}
+	if roleID := firstMatchingID(rule.AllowRoles, roles); roleID != "" {
+		return authorizationDecision{Allowed: true, Reason: source + " rule allows role " + roleID}
+	}
+
+	return authorizationDecision{Allowed: false, Reason: "not on the " + source + " rule's allow list"}
+}
+
+// restricted reports whether a permission is limited to an allow list in a guild, rather
+// than allowing everyone who isn't denied
+func (p AuthorizationPolicy) restricted(guildID string, permission Permission) bool {
+	rule := p.Default.rule(permission)
+	if guildRules, exists := p.Guilds[guildID]; exists && guildID != "" {
+		if guildRule := guildRules.rule(permission); guildRule != nil {
+			rule = guildRule
+		}
+	}
+
+	return rule != nil && (len(rule.AllowUsers) > 0 || len(rule.AllowRoles) > 0)
+}
+
+// Authorize checks the authorization policy and logs the decision with its reason
+func (c *Client) Authorize(guildID, userID string, member *discordgo.Member, permission Permission) bool {
+	decision := c.policy.check(guildID, userID, member, permission)
+
+	fields := []zap.Field{
+		zap.String("permission", string(permission)),
+		zap.String("guild_id", guildID),
+		zap.String("user_id", userID),
+		zap.String("reason", decision.Reason),
+	}
+	if decision.Allowed {
+		logger.Debug("Authorization allowed", fields...)
+	} else {
+		logger.Info("Authorization denied", fields...)
+	}
+
+	return decision.Allowed
+}
+
+// Restricted reports whether a permission is limited to an allow list in a guild
+func (c *Client) Restricted(guildID string, permission Permission) bool {
+	return c.policy.restricted(guildID, permission)
+}
+
+// DenyResponse returns the message sent to denied users, or an empty string to stay silent
+func (c *Client) DenyResponse() string {
+	return c.policy.DenyResponse
+}
+
+// containsID reports whether ids contains id
+func containsID(ids []string, id string) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// firstMatchingID returns the first of ids that is also in candidates
+func firstMatchingID(ids, candidates []string) string {
+	for _, id := range ids {
+		if containsID(candidates, id) {
+			return id
+		}
+	}
+
+	return ""
+}
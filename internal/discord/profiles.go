@@ -0,0 +1,271 @@
+package discord
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/justmiles/openwebui-discord/internal/openwebui"
+)
+
+// defaultAmbientWindowMinutes is how long after being addressed the bot keeps following a
+// conversation when no window is configured
+const defaultAmbientWindowMinutes = 20
+
+// Profile overrides settings for a guild, category or channel. Fields that are left
+// unset inherit the value resolved so far.
+type Profile struct {
+	Model string
+	// SystemPrompt is the persona, which is expanded with the action instructions
+	SystemPrompt string
+	// ToolIDs replaces the enabled tools when it is not nil, so an empty list disables them
+	ToolIDs     []string
+	Temperature *float64
+	TopP        *float64
+	MaxTokens   *int
+	// Actions lists the enabled actions when it is not nil, so an empty list disables them
+	Actions []string
+	// DeniedActions replaces the denied actions when it is not nil, and wins over Actions
+	DeniedActions []string
+	// ActionMode selects tool calls or markup for actions, inheriting when it is empty
+	ActionMode ActionMode
+	// Vision controls whether image attachments are sent to the model
+	Vision *bool
+	// Ambient controls whether the bot keeps replying to messages that don't address it
+	// after it was recently mentioned
+	Ambient              *bool
+	AmbientWindowMinutes int
+}
+
+// Profiles holds profiles keyed by guild, category and channel ID.
+//
+// Profiles are resolved in this order, with later levels overriding earlier ones:
+//  1. global defaults (the direct message prompt for direct messages)
+//  2. the guild profile
+//  3. the category profile
+//  4. the channel profile (the parent channel's, for threads)
+//  5. the thread profile
+//  6. /model and /persona overrides for the channel, then for the thread
+type Profiles struct {
+	Guilds     map[string]Profile
+	Categories map[string]Profile
+	Channels   map[string]Profile
+}
+
+// effectiveProfile is the result of layering every profile that applies to a conversation
+type effectiveProfile struct {
+	Model   string
+	Persona string
+	// SystemPrompt is the persona expanded with the instructions for the action mode
+	SystemPrompt string
+	ToolIDs      []string
+	Params       openwebui.GenerationParams
+	// Actions lists the enabled actions, or nil when every action is enabled
+	Actions []string
+	// DeniedActions lists actions that are disabled even when Actions enables them
+	DeniedActions        []string
+	ActionMode           ActionMode
+	Vision               bool
+	Ambient              bool
+	AmbientWindowMinutes int
+	// Sources describes each level that was applied, in order
+	Sources []string
+}
+
+// apply layers a profile's settings over the settings resolved so far
+func (p *effectiveProfile) apply(profile Profile, source string) {
+	if profile.Model != "" {
+		p.Model = profile.Model
+	}
+	if profile.SystemPrompt != "" {
+		p.Persona = profile.SystemPrompt
+	}
+	if profile.ToolIDs != nil {
+		p.ToolIDs = profile.ToolIDs
+	}
+	if profile.Temperature != nil {
+		p.Params.Temperature = profile.Temperature
+	}
+	if profile.TopP != nil {
+		p.Params.TopP = profile.TopP
+	}
+	if profile.MaxTokens != nil {
+		p.Params.MaxTokens = profile.MaxTokens
+	}
+	if profile.Actions != nil {
+		p.Actions = profile.Actions
+	}
+	if profile.DeniedActions != nil {
+		p.DeniedActions = profile.DeniedActions
+	}
+	if profile.ActionMode != "" {
+		p.ActionMode = profile.ActionMode
+	}
+	if profile.Vision != nil {
+		p.Vision = *profile.Vision
+	}
+	if profile.Ambient != nil {
+		p.Ambient = *profile.Ambient
+	}
+	if profile.AmbientWindowMinutes > 0 {
+		p.AmbientWindowMinutes = profile.AmbientWindowMinutes
+	}
+
+	p.Sources = append(p.Sources, source)
+}
+
+// actionEnabled reports whether the profile allows an action
+func (p *effectiveProfile) actionEnabled(actionType ActionType) bool {
+	if containsAction(p.DeniedActions, actionType) {
+		return false
+	}
+
+	return p.Actions == nil || containsAction(p.Actions, actionType)
+}
+
+// containsAction reports whether a list of action names includes an action type
+func containsAction(names []string, actionType ActionType) bool {
+	for _, name := range names {
+		if strings.EqualFold(name, string(actionType)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveProfile works out the settings for a conversation by layering the profiles that
+// apply to it over the global defaults
+func (h *OpenWebUIHandler) resolveProfile(s *discordgo.Session, guildID, channelID string, isDirect bool) *effectiveProfile {
+	profile := &effectiveProfile{
+		Model:                h.openwebui.Model(),
+		Persona:              h.systemPrompt,
+		ToolIDs:              h.openwebui.ToolIDs(),
+		Params:               h.openwebui.Params(),
+		ActionMode:           h.actionMode,
+		Vision:               h.attachments.Images,
+		Ambient:              true,
+		AmbientWindowMinutes: h.ambient.WindowMinutes,
+		Sources:              []string{"defaults"},
+	}
+
+	if isDirect {
+		if h.dmSystemPrompt != "" {
+			profile.Persona = h.dmSystemPrompt
+			profile.Sources = append(profile.Sources, "direct messages")
+		}
+		profile.SystemPrompt = h.systemPromptFor(profile)
+		return profile
+	}
+
+	// Threads take their parent channel's settings before their own
+	channelIDs := []string{channelID}
+	categoryID := ""
+	if channel := lookupChannel(s, channelID); channel != nil {
+		if channel.IsThread() {
+			channelIDs = []string{channel.ParentID, channelID}
+			if parent := lookupChannel(s, channel.ParentID); parent != nil {
+				categoryID = parent.ParentID
+			}
+		} else {
+			categoryID = channel.ParentID
+		}
+	}
+
+	if guildProfile, exists := h.profiles.Guilds[guildID]; exists {
+		profile.apply(guildProfile, "guild "+guildID)
+	}
+	if categoryProfile, exists := h.profiles.Categories[categoryID]; exists && categoryID != "" {
+		profile.apply(categoryProfile, "category "+categoryID)
+	}
+	for _, id := range channelIDs {
+		if channelProfile, exists := h.profiles.Channels[id]; exists {
+			profile.apply(channelProfile, "channel "+id)
+		}
+	}
+
+	h.overridesMutex.RLock()
+	for _, id := range channelIDs {
+		if override, exists := h.overrides[id]; exists {
+			if override.Model != "" {
+				profile.Model = override.Model
+			}
+			if override.Persona != "" {
+				profile.Persona = override.Persona
+			}
+			profile.Sources = append(profile.Sources, "overrides for "+id)
+		}
+	}
+	h.overridesMutex.RUnlock()
+
+	// Expand the persona once the action mode is known
+	profile.SystemPrompt = h.systemPromptFor(profile)
+
+	return profile
+}
+
+// clientFor returns an OpenWebUI client configured for a profile
+func (h *OpenWebUIHandler) clientFor(profile *effectiveProfile) *openwebui.Client {
+	return h.openwebui.
+		WithModel(profile.Model).
+		WithToolIDs(profile.ToolIDs).
+		WithParams(profile.Params)
+}
+
+// describeProfile formats an effective profile for the /profile debug command
+func describeProfile(profile *effectiveProfile) string {
+	var sb strings.Builder
+
+	sb.WriteString("**Effective profile**\n")
+	sb.WriteString("Resolution order: defaults → guild → category → channel → thread → /model and /persona overrides\n")
+	fmt.Fprintf(&sb, "Applied: %s\n", strings.Join(profile.Sources, " → "))
+	fmt.Fprintf(&sb, "Model: `%s`\n", profile.Model)
+	fmt.Fprintf(&sb, "Tools: %s\n", describeList(profile.ToolIDs, "none"))
+	fmt.Fprintf(&sb, "Temperature: %s\n", describeFloat(profile.Params.Temperature))
+	fmt.Fprintf(&sb, "Top P: %s\n", describeFloat(profile.Params.TopP))
+	if profile.Params.MaxTokens != nil {
+		fmt.Fprintf(&sb, "Max tokens: %d\n", *profile.Params.MaxTokens)
+	} else {
+		sb.WriteString("Max tokens: model default\n")
+	}
+	if profile.Actions == nil {
+		sb.WriteString("Actions: all\n")
+	} else {
+		fmt.Fprintf(&sb, "Actions: %s\n", describeList(profile.Actions, "none"))
+	}
+	if len(profile.DeniedActions) > 0 {
+		fmt.Fprintf(&sb, "Denied actions: %s\n", describeList(profile.DeniedActions, "none"))
+	}
+	fmt.Fprintf(&sb, "Action mode: %s\n", profile.ActionMode)
+	if profile.Vision {
+		sb.WriteString("Images: sent to the model\n")
+	} else {
+		sb.WriteString("Images: described in a note\n")
+	}
+	if profile.Ambient {
+		fmt.Fprintf(&sb, "Ambient replies: on, for %d minutes after being addressed\n", profile.AmbientWindowMinutes)
+	} else {
+		sb.WriteString("Ambient replies: off\n")
+	}
+	fmt.Fprintf(&sb, "System prompt: %d characters", len(profile.SystemPrompt))
+
+	return sb.String()
+}
+
+// describeList formats a list of names, or fallback when it is empty
+func describeList(values []string, fallback string) string {
+	if len(values) == 0 {
+		return fallback
+	}
+
+	return "`" + strings.Join(values, "`, `") + "`"
+}
+
+// describeFloat formats an optional parameter
+func describeFloat(value *float64) string {
+	if value == nil {
+		return "model default"
+	}
+
+	return fmt.Sprintf("%g", *value)
+}
@@ -0,0 +1,105 @@
+package discord
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// QueueOptions controls how incoming messages are queued for processing
+type QueueOptions struct {
+	// MaxDepth is how many messages can wait per conversation, including the one being handled
+	MaxDepth int
+	// Workers is how many conversations are handled at the same time
+	Workers int
+	// Debounce is how long to wait for more messages in a conversation before answering
+	// them together, 0 to answer each message on its own
+	Debounce time.Duration
+}
+
+// workQueue runs jobs one at a time per key, in the order they arrived, while different
+// keys run in parallel on a bounded number of workers
+type workQueue struct {
+	maxDepth int
+	workers  chan struct{}
+	queues   map[string]*keyQueue
+	mutex    sync.Mutex
+}
+
+// keyQueue holds the jobs waiting for a single key
+type keyQueue struct {
+	jobs    []func()
+	running bool
+}
+
+// newWorkQueue creates a work queue from the options
+func newWorkQueue(options QueueOptions) *workQueue {
+	workers := options.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &workQueue{
+		maxDepth: options.MaxDepth,
+		workers:  make(chan struct{}, workers),
+		queues:   make(map[string]*keyQueue),
+	}
+}
+
+// Enqueue adds a job for a key and returns how many jobs are ahead of it. It returns
+// false without queueing the job if the key's queue is full.
+func (q *workQueue) Enqueue(key string, job func()) (int, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	queue, exists := q.queues[key]
+	if !exists {
+		queue = &keyQueue{}
+		q.queues[key] = queue
+	}
+
+	ahead := len(queue.jobs)
+	if queue.running {
+		ahead++
+	}
+	if q.maxDepth > 0 && ahead >= q.maxDepth {
+		return ahead, false
+	}
+
+	queue.jobs = append(queue.jobs, job)
+	if !queue.running {
+		queue.running = true
+		go q.drain(key, queue)
+	}
+
+	return ahead, true
+}
+
+// drain runs a key's jobs until its queue is empty, taking a worker for each job so
+// busy keys don't starve the others
+func (q *workQueue) drain(key string, queue *keyQueue) {
+	for {
+		q.mutex.Lock()
+		if len(queue.jobs) == 0 {
+			queue.running = false
+			delete(q.queues, key)
+			q.mutex.Unlock()
+			return
+		}
+		job := queue.jobs[0]
+		queue.jobs = queue.jobs[1:]
+		q.mutex.Unlock()
+
+		q.workers <- struct{}{}
+		job()
+		<-q.workers
+	}
+}
+
+// queuePositionMessage tells a user how many messages will be answered before theirs
+func queuePositionMessage(ahead int) string {
+	if ahead == 1 {
+		return "I'm answering another message here, I'll get to yours next."
+	}
+	return fmt.Sprintf("I'm busy with other messages here, yours is number %d in line.", ahead)
+}
@@ -0,0 +1,77 @@
+package discord
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/justmiles/openwebui-discord/internal/ratelimit"
+)
+
+// rateLimitNotices remembers which users were told about a cooldown so they are only
+// told once while it lasts
+type rateLimitNotices struct {
+	until map[string]time.Time
+	mutex sync.Mutex
+}
+
+// newRateLimitNotices creates an empty notice tracker
+func newRateLimitNotices() *rateLimitNotices {
+	return &rateLimitNotices{until: make(map[string]time.Time)}
+}
+
+// shouldNotify reports whether a user should be told about a rejection, recording the
+// notice if so
+func (n *rateLimitNotices) shouldNotify(userID string, rejection *ratelimit.Rejection) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	now := time.Now()
+	if until, exists := n.until[userID]; exists && now.Before(until) {
+		return false
+	}
+
+	// Forget expired notices so the map doesn't grow with every user ever limited
+	for id, until := range n.until {
+		if now.After(until) {
+			delete(n.until, id)
+		}
+	}
+
+	n.until[userID] = now.Add(rejection.RetryAfter)
+	return true
+}
+
+// rateLimitMessage explains a rejection to the user, including when to try again
+func rateLimitMessage(rejection *ratelimit.Rejection) string {
+	retry := formatRetryAfter(rejection.RetryAfter)
+
+	switch rejection.Level {
+	case ratelimit.LevelUser:
+		return fmt.Sprintf("You're sending messages too quickly. Please try again in %s.", retry)
+	case ratelimit.LevelChannel:
+		return fmt.Sprintf("This channel is sending me too many messages. Please try again in %s.", retry)
+	case ratelimit.LevelGuild:
+		return fmt.Sprintf("This server is sending me too many messages. Please try again in %s.", retry)
+	default:
+		return fmt.Sprintf("I'm receiving too many messages right now. Please try again in %s.", retry)
+	}
+}
+
+// formatRetryAfter rounds a wait up to whole seconds for display
+func formatRetryAfter(d time.Duration) string {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds <= 1 {
+		return "a second"
+	}
+	if seconds < 60 {
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+
+	minutes := int(math.Ceil(float64(seconds) / 60))
+	if minutes == 1 {
+		return "a minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
@@ -0,0 +1,93 @@
+package discord
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"go.uber.org/zap"
+)
+
+// maxQuotedLength is the longest a quoted message in a reply chain can be before it is shortened
+const maxQuotedLength = 500
+
+// replyChain returns the messages a message replies to, oldest first, following the
+// chain of replies for up to maxDepth hops
+func replyChain(s *discordgo.Session, m *discordgo.Message, maxDepth int) []*discordgo.Message {
+	var chain []*discordgo.Message
+
+	current := m
+	for len(chain) < maxDepth && current.MessageReference != nil {
+		referenced := current.ReferencedMessage
+		if referenced == nil {
+			referenced = fetchMessage(s, current.MessageReference.ChannelID, current.MessageReference.MessageID)
+		}
+		if referenced == nil {
+			break
+		}
+
+		chain = append(chain, referenced)
+		current = referenced
+	}
+
+	// Reverse so the chain reads in conversation order
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+
+	return chain
+}
+
+// fetchMessage looks up a message in the state cache, falling back to the REST API
+func fetchMessage(s *discordgo.Session, channelID, messageID string) *discordgo.Message {
+	if msg, err := s.State.Message(channelID, messageID); err == nil {
+		return msg
+	}
+
+	msg, err := s.ChannelMessage(channelID, messageID)
+	if err != nil {
+		logger.Debug("Failed to fetch referenced message",
+			zap.String("channel_id", channelID),
+			zap.String("message_id", messageID),
+			zap.Error(err),
+		)
+		return nil
+	}
+
+	return msg
+}
+
+// quoteReplyChain formats a reply chain as quoted context for the user's turn
+func quoteReplyChain(s *discordgo.Session, guildID string, chain []*discordgo.Message) string {
+	if len(chain) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	for i, msg := range chain {
+		speaker := "you"
+		if msg.Author != nil && msg.Author.ID != s.State.User.ID {
+			speaker = displayName(s, guildID, msg.Member, msg.Author)
+		}
+
+		content := resolveInboundMentions(s, guildID, cleanMessage(s, msg.Content))
+		if len(content) > maxQuotedLength {
+			content = strings.ToValidUTF8(content[:maxQuotedLength], "") + "…"
+		}
+		if content == "" && len(msg.Attachments) > 0 {
+			content = fmt.Sprintf("[%d attachment(s)]", len(msg.Attachments))
+		}
+
+		if i == len(chain)-1 {
+			fmt.Fprintf(&sb, "In reply to %s:\n", speaker)
+		} else {
+			fmt.Fprintf(&sb, "Earlier in the thread, %s said:\n", speaker)
+		}
+		for _, line := range strings.Split(content, "\n") {
+			sb.WriteString("> " + line + "\n")
+		}
+	}
+
+	return sb.String()
+}
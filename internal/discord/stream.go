@@ -0,0 +1,158 @@
+package discord
+
+import (
+	"context"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// streamPlaceholder is shown while waiting for the first streamed tokens
+	streamPlaceholder = "…"
+
+	// streamEditInterval throttles progressive edits to stay clear of Discord rate limits
+	streamEditInterval = 1500 * time.Millisecond
+
+	// streamMessageLength is the length at which streamed content rolls over into a new message
+	streamMessageLength = 1900
+)
+
+// streamingMessage renders a growing response across one or more Discord messages,
+// editing them in place as new content arrives
+type streamingMessage struct {
+	client     *Client
+	channelID  string
+	replyToID  string
+	messageIDs []string
+	rendered   []string
+	lastEdit   time.Time
+	components []discordgo.MessageComponent
+}
+
+// newStreamingMessage creates a streaming message for a channel without sending anything yet.
+// The first message is sent as a reply to replyToID when it is set.
+func newStreamingMessage(client *Client, channelID, replyToID string) *streamingMessage {
+	return &streamingMessage{
+		client:    client,
+		channelID: channelID,
+		replyToID: replyToID,
+	}
+}
+
+// WithComponents attaches components, such as a Stop button, to the first message until
+// the response is finished
+func (sm *streamingMessage) WithComponents(components []discordgo.MessageComponent) *streamingMessage {
+	sm.components = components
+	return sm
+}
+
+// Placeholder posts the placeholder message if nothing has been sent yet
+func (sm *streamingMessage) Placeholder(ctx context.Context) error {
+	if len(sm.messageIDs) > 0 {
+		return nil
+	}
+
+	return sm.render(ctx, []string{streamPlaceholder})
+}
+
+// Update shows the latest content, skipping the edit if the previous one was too recent.
+// Content that no longer fits in the current message always rolls over immediately.
+func (sm *streamingMessage) Update(ctx context.Context, content string) error {
+	if content == "" {
+		return nil
+	}
+
+	parts := splitMessage(content, streamMessageLength)
+	if len(parts) <= len(sm.messageIDs) && time.Since(sm.lastEdit) < streamEditInterval {
+		return nil
+	}
+
+	return sm.render(ctx, parts)
+}
+
+// Finish renders the final content and removes any messages it no longer needs.
+// Empty content removes every message that was sent.
+func (sm *streamingMessage) Finish(ctx context.Context, content string) error {
+	var parts []string
+	if content != "" {
+		parts = splitMessage(content, streamMessageLength)
+	}
+
+	if err := sm.render(ctx, parts); err != nil {
+		return err
+	}
+
+	for len(sm.messageIDs) > len(parts) {
+		last := len(sm.messageIDs) - 1
+		if err := sm.client.DeleteMessage(sm.channelID, sm.messageIDs[last]); err != nil {
+			return err
+		}
+		sm.messageIDs = sm.messageIDs[:last]
+		sm.rendered = sm.rendered[:last]
+	}
+
+	// The finished response no longer needs its components
+	if len(sm.components) > 0 && len(sm.messageIDs) > 0 {
+		if err := sm.client.ClearComponents(sm.channelID, sm.messageIDs[0]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LastMessageID returns the ID of the most recently sent message, if any
+func (sm *streamingMessage) LastMessageID() string {
+	if len(sm.messageIDs) == 0 {
+		return ""
+	}
+
+	return sm.messageIDs[len(sm.messageIDs)-1]
+}
+
+// render edits existing messages whose content changed and sends new messages for extra parts
+func (sm *streamingMessage) render(ctx context.Context, parts []string) error {
+	for i, part := range parts {
+		if i < len(sm.messageIDs) {
+			if sm.rendered[i] == part {
+				continue
+			}
+
+			if err := sm.client.EditMessage(sm.channelID, sm.messageIDs[i], part); err != nil {
+				return err
+			}
+			sm.rendered[i] = part
+			continue
+		}
+
+		replyToID := ""
+		if i == 0 {
+			replyToID = sm.replyToID
+		}
+
+		var messageID string
+		var err error
+		if i == 0 && len(sm.components) > 0 {
+			messageID, err = sm.client.SendReplyWithComponents(ctx, sm.channelID, replyToID, part, sm.components)
+		} else {
+			messageID, err = sm.client.SendReply(ctx, sm.channelID, replyToID, part)
+		}
+		if err != nil {
+			return err
+		}
+
+		logger.Debug("Rolled streamed response into a new message",
+			zap.String("channel_id", sm.channelID),
+			zap.Int("part", i+1),
+		)
+
+		sm.messageIDs = append(sm.messageIDs, messageID)
+		sm.rendered = append(sm.rendered, part)
+	}
+
+	sm.lastEdit = time.Now()
+	return nil
+}
@@ -0,0 +1,52 @@
+package discord
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxThreadNameLength keeps thread names well inside Discord's 100 character limit
+const maxThreadNameLength = 80
+
+// ThreadOptions controls which channels hold their conversations in threads
+type ThreadOptions struct {
+	// Channels are the IDs of channels where a mention starts a thread off the message
+	Channels []string
+	// AutoArchiveMinutes is how long a thread can be inactive before Discord archives it
+	AutoArchiveMinutes int
+}
+
+// startsThread reports whether a mention in the channel should start a thread
+func (o ThreadOptions) startsThread(channelID string) bool {
+	for _, threadChannel := range o.Channels {
+		if channelID == threadChannel {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isOwnThread reports whether a channel is a thread the bot started, where every
+// message is part of the conversation
+func isOwnThread(s *discordgo.Session, channelID string) bool {
+	channel := lookupChannel(s, channelID)
+	return channel != nil && channel.IsThread() && channel.OwnerID == s.State.User.ID
+}
+
+// threadName names a conversation thread after the first line of the message that started it
+func threadName(content, speaker string) string {
+	name := strings.TrimSpace(strings.SplitN(content, "\n", 2)[0])
+	if name == "" {
+		return "Conversation with " + speaker
+	}
+
+	if utf8.RuneCountInString(name) > maxThreadNameLength {
+		runes := []rune(name)
+		name = strings.TrimSpace(string(runes[:maxThreadNameLength-1])) + "…"
+	}
+
+	return name
+}
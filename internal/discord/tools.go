@@ -0,0 +1,139 @@
+package discord
+
+import (
+	"context"
+
+	"github.com/justmiles/openwebui-discord/internal/logger"
+	"github.com/justmiles/openwebui-discord/internal/openwebui"
+	"github.com/justmiles/openwebui-discord/internal/prompt"
+	"go.uber.org/zap"
+)
+
+// ActionMode selects how the model asks for Discord actions
+type ActionMode string
+
+const (
+	// ActionModeTools offers actions as functions the model calls natively
+	ActionModeTools ActionMode = "tools"
+	// ActionModeMarkup asks the model to write [ACTION:type|params] markup in its reply,
+	// for models without tool support
+	ActionModeMarkup ActionMode = "markup"
+)
+
+// maxToolRounds caps how many times the model can call tools before it has to reply
+const maxToolRounds = 3
+
+// actionRunner checks an action the model asked for while generating against the action
+// policy and carries it out when it is allowed, or defers it until the reply is ready
+type actionRunner func(action Action) actionDecision
+
+// systemPromptFor expands a profile's persona with the instructions for its action mode
+// and the actions it enables
+func (h *OpenWebUIHandler) systemPromptFor(profile *effectiveProfile) string {
+	actions := h.actions.Describe(profile.actionEnabled)
+	if profile.ActionMode == ActionModeMarkup {
+		return prompt.GenerateSystemPrompt(profile.Persona, actions)
+	}
+
+	return prompt.GenerateToolSystemPrompt(profile.Persona, actions)
+}
+
+// runToolCall carries out one tool call, returning the result to report to the model and
+// the action when it has to wait for the reply
+func (h *OpenWebUIHandler) runToolCall(call openwebui.ToolCall, runActions actionRunner) (string, *Action) {
+	action, err := h.actions.FromToolCall(call)
+	if err != nil {
+		return "error: " + err.Error(), nil
+	}
+	if runActions == nil {
+		return "error: this action can't be used here", nil
+	}
+
+	logger.Debug("Model called action tool", zap.String("type", string(action.Type)), zap.String("params", action.Parameters))
+
+	decision := runActions(action)
+	if decision.Outcome == actionDeferred {
+		return decision.toolResult(), &action
+	}
+
+	return decision.toolResult(), nil
+}
+
+// completeWithTools gets a completion that may call action tools, carrying out the calls
+// and sending their results back until the model replies. It returns the reply and the
+// actions that apply to it.
+func (h *OpenWebUIHandler) completeWithTools(ctx context.Context, client *openwebui.Client, messages []openwebui.Message, profile *effectiveProfile, onProgress func(partial string), runActions actionRunner) (*modelReply, []Action, error) {
+	tools := h.actions.Tools(profile.actionEnabled)
+	var deferred []Action
+	var usage *openwebui.Usage
+	var firstPromptTokens int
+
+	for round := 0; ; round++ {
+		// The last round offers no tools so the model has to reply
+		roundClient := client
+		if round < maxToolRounds {
+			roundClient = client.WithTools(tools)
+		}
+
+		reply, err := complete(ctx, roundClient, messages, onProgress)
+		if err != nil {
+			return nil, nil, err
+		}
+		usage = addUsage(usage, reply.Usage)
+		if reply.Usage != nil {
+			if round == 0 {
+				firstPromptTokens = reply.Usage.PromptTokens
+			}
+			logger.Debug("Completed tool round",
+				zap.Int("round", round+1),
+				zap.Int("prompt_tokens", reply.Usage.PromptTokens),
+				zap.Int("completion_tokens", reply.Usage.CompletionTokens),
+			)
+		}
+
+		if len(reply.ToolCalls) == 0 || round == maxToolRounds {
+			reply.Usage = usage
+			reply.FirstPromptTokens = firstPromptTokens
+			return reply, deferred, nil
+		}
+
+		// Send the calls back with their results so the model can write its reply
+		messages = append(messages, openwebui.Message{
+			Role:      "assistant",
+			Content:   reply.Content,
+			ToolCalls: reply.ToolCalls,
+		})
+		for _, call := range reply.ToolCalls {
+			result, action := h.runToolCall(call, runActions)
+			if action != nil {
+				deferred = append(deferred, *action)
+			}
+
+			messages = append(messages, openwebui.Message{
+				Role:       "tool",
+				Content:    result,
+				ToolCallID: call.ID,
+			})
+		}
+
+		logger.Debug("Sent tool results back to the model",
+			zap.Int("round", round+1),
+			zap.Int("calls", len(reply.ToolCalls)),
+		)
+	}
+}
+
+// addUsage adds the usage reported for one request to a running total
+func addUsage(total, usage *openwebui.Usage) *openwebui.Usage {
+	if usage == nil {
+		return total
+	}
+	if total == nil {
+		total = &openwebui.Usage{}
+	}
+
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
+	return total
+}
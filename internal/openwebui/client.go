@@ -1,6 +1,7 @@
 package openwebui
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -8,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/justmiles/openwebui-discord/internal/logger"
@@ -17,38 +19,100 @@ import (
 
 // Client represents an OpenWebUI API client
 type Client struct {
-	endpoint    string
-	apiKey      string
-	model       string
-	toolIDs     []string
-	timeout     time.Duration
-	client      *http.Client
-	rateLimiter *ratelimit.Limiter
+	endpoint     string
+	apiKey       string
+	model        string
+	toolIDs      []string
+	tools        []Tool
+	params       GenerationParams
+	timeout      time.Duration
+	client       *http.Client
+	streamClient *http.Client
+	rateLimiter  *ratelimit.Limiter
+
+	tokenBudget       int
+	modelTokenBudgets map[string]int
 }
 
 // NewClient creates a new OpenWebUI API client
 func NewClient(endpoint, apiKey, model string, toolIDs []string, timeoutSeconds, requestsPerMinute int) *Client {
 	return &Client{
-		endpoint:    endpoint,
-		apiKey:      apiKey,
-		model:       model,
-		toolIDs:     toolIDs,
-		timeout:     time.Duration(timeoutSeconds) * time.Second,
-		client:      &http.Client{Timeout: time.Duration(timeoutSeconds) * time.Second},
-		rateLimiter: ratelimit.NewLimiter(requestsPerMinute),
+		endpoint: endpoint,
+		apiKey:   apiKey,
+		model:    model,
+		toolIDs:  toolIDs,
+		timeout:  time.Duration(timeoutSeconds) * time.Second,
+		client:   &http.Client{Timeout: time.Duration(timeoutSeconds) * time.Second},
+		// Streams can outlive the request timeout, which is enforced between chunks instead
+		streamClient: &http.Client{},
+		rateLimiter:  ratelimit.NewLimiter(requestsPerMinute),
 	}
 }
 
+// WithModel returns a copy of the client that sends requests to a different model.
+// The copy shares the HTTP client and rate limiter with the original.
+func (c *Client) WithModel(model string) *Client {
+	clone := *c
+	clone.model = model
+	return &clone
+}
+
+// WithToolIDs returns a copy of the client that enables a different set of tools
+func (c *Client) WithToolIDs(toolIDs []string) *Client {
+	clone := *c
+	clone.toolIDs = toolIDs
+	return &clone
+}
+
+// WithTools returns a copy of the client that offers functions for the model to call
+func (c *Client) WithTools(tools []Tool) *Client {
+	clone := *c
+	clone.tools = tools
+	return &clone
+}
+
+// WithParams returns a copy of the client that sends different generation parameters
+func (c *Client) WithParams(params GenerationParams) *Client {
+	clone := *c
+	clone.params = params
+	return &clone
+}
+
+// RateLimitStats returns a snapshot of the client's request rate limiter
+func (c *Client) RateLimitStats() ratelimit.Stats {
+	return c.rateLimiter.Stats()
+}
+
+// Model returns the model used for completions
+func (c *Client) Model() string {
+	return c.model
+}
+
+// ToolIDs returns the tools enabled for completions
+func (c *Client) ToolIDs() []string {
+	return c.toolIDs
+}
+
+// Params returns the generation parameters sent with completions
+func (c *Client) Params() GenerationParams {
+	return c.params
+}
+
 // ChatCompletion sends a chat completion request to the OpenWebUI API
 func (c *Client) ChatCompletion(ctx context.Context, messages []Message) (*ChatCompletionResponse, error) {
 	// Apply rate limiting
-	c.rateLimiter.Wait()
+	if err := c.rateLimiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("error waiting for rate limit: %w", err)
+	}
 
 	// Create request
 	reqBody := ChatCompletionRequest{
 		Model:    c.model,
 		ToolIDs:  c.toolIDs,
 		Messages: messages,
+		Tools:    c.tools,
+
+		GenerationParams: c.params,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -94,14 +158,7 @@ func (c *Client) ChatCompletion(ctx context.Context, messages []Message) (*ChatC
 
 	// Check for error response
 	if resp.StatusCode != http.StatusOK {
-		var errResp ErrorResponse
-		if err := json.Unmarshal(body, &errResp); err == nil {
-			return nil, fmt.Errorf("API error: %s (type: %s, code: %s)",
-				errResp.Error.Message,
-				errResp.Error.Type,
-				errResp.Error.Code)
-		}
-		return nil, fmt.Errorf("API error: status %d, body: %s", resp.StatusCode, string(body))
+		return nil, newAPIError(resp.StatusCode, body)
 	}
 
 	// Parse response
@@ -127,6 +184,212 @@ func (c *Client) ChatCompletion(ctx context.Context, messages []Message) (*ChatC
 	return &chatResp, nil
 }
 
+// ChatCompletionStream sends a streaming chat completion request to the OpenWebUI API.
+// Content deltas are delivered on the returned channel, which is closed when the stream
+// ends. An error that interrupts the stream is delivered as a final delta with Err set, so
+// callers must keep reading until the channel is closed.
+func (c *Client) ChatCompletionStream(ctx context.Context, messages []Message) (<-chan StreamDelta, error) {
+	// Apply rate limiting
+	if err := c.rateLimiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("error waiting for rate limit: %w", err)
+	}
+
+	// Create request
+	reqBody := ChatCompletionRequest{
+		Model:    c.model,
+		ToolIDs:  c.toolIDs,
+		Messages: messages,
+		Tools:    c.tools,
+		Stream:   true,
+		StreamOptions: &StreamOptions{
+			IncludeUsage: true,
+		},
+
+		GenerationParams: c.params,
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	logger.Debug("Full streaming request: " + string(jsonData))
+
+	// Cancel the stream if no data arrives within the timeout
+	ctx, cancel := context.WithCancel(ctx)
+	idleTimer := time.AfterFunc(c.timeout, cancel)
+
+	// Create HTTP request
+	url := fmt.Sprintf("%s/api/chat/completions", c.endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		idleTimer.Stop()
+		cancel()
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	// Set headers
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
+
+	logger.Debug("Sending streaming request to OpenWebUI API",
+		zap.String("url", url),
+		zap.Int("message_count", len(messages)),
+	)
+
+	// Send request
+	resp, err := c.streamClient.Do(req)
+	if err != nil {
+		idleTimer.Stop()
+		cancel()
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+
+	// Check for error response
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		defer cancel()
+		idleTimer.Stop()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading response: %w", err)
+		}
+
+		return nil, newAPIError(resp.StatusCode, body)
+	}
+
+	deltas := make(chan StreamDelta)
+	go func() {
+		defer close(deltas)
+		defer resp.Body.Close()
+		defer cancel()
+		defer idleTimer.Stop()
+
+		// The context is usually done by now, so the error is always delivered; consumers
+		// drain the channel until it is closed
+		if err := c.readStream(ctx, resp.Body, idleTimer, deltas); err != nil {
+			deltas <- StreamDelta{Err: err}
+		}
+	}()
+
+	return deltas, nil
+}
+
+// readStream parses server-sent events from a streaming response and forwards content deltas
+func (c *Client) readStream(ctx context.Context, body io.Reader, idleTimer *time.Timer, deltas chan<- StreamDelta) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var chunks int
+	var calls toolCallBuilder
+	for scanner.Scan() {
+		idleTimer.Reset(c.timeout)
+
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			// Skip blank separators, comments and other SSE fields
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			logger.Debug("Completed streaming response from OpenWebUI API", zap.Int("chunks", chunks))
+			return sendToolCalls(ctx, calls.calls, deltas)
+		}
+
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("error parsing stream chunk: %w", err)
+		}
+		chunks++
+
+		if chunk.Usage != nil {
+			logger.Debug("Received usage from OpenWebUI stream",
+				zap.Int("total_tokens", chunk.Usage.TotalTokens),
+			)
+
+			select {
+			case deltas <- StreamDelta{Usage: chunk.Usage}:
+			case <-ctx.Done():
+				return fmt.Errorf("stream cancelled: %w", ctx.Err())
+			}
+		}
+
+		for _, choice := range chunk.Choices {
+			calls.add(choice.Delta.ToolCalls)
+			if choice.Delta.Content == "" {
+				continue
+			}
+
+			select {
+			case deltas <- StreamDelta{Content: choice.Delta.Content}:
+			case <-ctx.Done():
+				return fmt.Errorf("stream cancelled: %w", ctx.Err())
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading stream: %w", err)
+	}
+
+	// Some servers close the connection without sending [DONE]
+	logger.Debug("Streaming response ended without done marker", zap.Int("chunks", chunks))
+	return sendToolCalls(ctx, calls.calls, deltas)
+}
+
+// toolCallBuilder assembles tool calls from the pieces spread across stream chunks
+type toolCallBuilder struct {
+	calls []ToolCall
+}
+
+// add merges streamed tool call pieces, appending argument fragments to the call with
+// the same index
+func (b *toolCallBuilder) add(pieces []ToolCall) {
+	for _, piece := range pieces {
+		index := len(b.calls)
+		if piece.Index != nil {
+			index = *piece.Index
+		}
+		for len(b.calls) <= index {
+			b.calls = append(b.calls, ToolCall{})
+		}
+
+		call := &b.calls[index]
+		if piece.ID != "" {
+			call.ID = piece.ID
+		}
+		if piece.Type != "" {
+			call.Type = piece.Type
+		}
+		if piece.Function.Name != "" {
+			call.Function.Name = piece.Function.Name
+		}
+		call.Function.Arguments += piece.Function.Arguments
+	}
+}
+
+// sendToolCalls delivers the tool calls assembled from a stream, if there are any
+func sendToolCalls(ctx context.Context, calls []ToolCall, deltas chan<- StreamDelta) error {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	// The index only matters while the calls are being assembled
+	for i := range calls {
+		calls[i].Index = nil
+	}
+
+	select {
+	case deltas <- StreamDelta{ToolCalls: calls}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("stream cancelled: %w", ctx.Err())
+	}
+}
+
 // GetCompletion is a convenience method that returns just the completion text
 func (c *Client) GetCompletion(ctx context.Context, messages []Message) (string, error) {
 	resp, err := c.ChatCompletion(ctx, messages)
@@ -143,6 +406,17 @@ func (c *Client) GetCompletion(ctx context.Context, messages []Message) (string,
 
 // WithRetry attempts to get a completion with retries and exponential backoff
 func (c *Client) WithRetry(ctx context.Context, messages []Message, maxRetries int) (string, error) {
+	resp, err := c.ChatCompletionWithRetry(ctx, messages, maxRetries)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Choices[0].Message.Content, nil
+}
+
+// ChatCompletionWithRetry attempts a chat completion with retries and exponential backoff,
+// returning the full response so callers can inspect token usage
+func (c *Client) ChatCompletionWithRetry(ctx context.Context, messages []Message, maxRetries int) (*ChatCompletionResponse, error) {
 	var lastErr error
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
@@ -163,12 +437,15 @@ func (c *Client) WithRetry(ctx context.Context, messages []Message, maxRetries i
 			case <-time.After(backoffDuration):
 				// Continue after backoff
 			case <-ctx.Done():
-				return "", fmt.Errorf("context cancelled during backoff: %w", ctx.Err())
+				return nil, fmt.Errorf("context cancelled during backoff: %w", ctx.Err())
 			}
 		}
 
 		// Attempt the request
-		completion, err := c.GetCompletion(ctx, messages)
+		resp, err := c.ChatCompletion(ctx, messages)
+		if err == nil && len(resp.Choices) == 0 {
+			err = errors.New("no completion choices returned")
+		}
 		if err == nil {
 			// Success!
 			if attempt > 0 {
@@ -176,19 +453,24 @@ func (c *Client) WithRetry(ctx context.Context, messages []Message, maxRetries i
 					zap.Int("attempts", attempt+1),
 				)
 			}
-			return completion, nil
+			return resp, nil
 		}
 
 		// Save the error for potential logging
 		lastErr = err
 
+		// Stop retrying once the caller has given up on the request
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("request cancelled: %w", ctx.Err())
+		}
+
 		// Check if we should retry based on the error
 		if !isRetryableError(err) {
-			return "", fmt.Errorf("non-retryable error: %v", err)
+			return nil, fmt.Errorf("non-retryable error: %w", err)
 		}
 	}
 
-	return "", fmt.Errorf("max retries exceeded: %w", lastErr)
+	return nil, fmt.Errorf("max retries exceeded: %w", lastErr)
 }
 
 // isRetryableError determines if an error should be retried
@@ -212,6 +494,12 @@ func isRetryableError(err error) bool {
 		return true
 	}
 
+	// Retry API errors that are rate limits or server failures
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+
 	// For HTTP errors, we might want to retry on 429 (Too Many Requests) or 5xx errors
 	// This would require parsing the error string, which is not ideal but works for this example
 	errStr := err.Error()
@@ -222,6 +510,51 @@ func isRetryableError(err error) bool {
 	return false
 }
 
+// APIError is an error response from the OpenWebUI API
+type APIError struct {
+	StatusCode int
+	message    string
+}
+
+func (e *APIError) Error() string {
+	return e.message
+}
+
+// newAPIError describes an error response, using the API's error message when the body has one
+func newAPIError(statusCode int, body []byte) *APIError {
+	var errResp ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err == nil {
+		return &APIError{
+			StatusCode: statusCode,
+			message: fmt.Sprintf("API error: %s (type: %s, code: %s)",
+				errResp.Error.Message,
+				errResp.Error.Type,
+				errResp.Error.Code),
+		}
+	}
+
+	return &APIError{
+		StatusCode: statusCode,
+		message:    fmt.Sprintf("API error: status %d, body: %s", statusCode, string(body)),
+	}
+}
+
+// IsRequestRejected reports whether the API rejected a request's content as invalid, such
+// as image parts sent to a model without vision support
+func IsRequestRejected(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+		return true
+	}
+
+	return false
+}
+
 // contains checks if a string contains a substring
 func contains(s, substr string) bool {
 	return bytes.Contains([]byte(s), []byte(substr))
@@ -0,0 +1,146 @@
+package openwebui
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// charsPerToken is the rough number of bytes per token for English text
+	charsPerToken = 4
+
+	// messageOverheadTokens accounts for the role and separators added to every message
+	messageOverheadTokens = 4
+
+	// replyOverheadTokens accounts for the tokens that prime the assistant's reply
+	replyOverheadTokens = 3
+
+	// minTruncatedTokens is the smallest remaining budget worth filling with a truncated message
+	minTruncatedTokens = 32
+
+	// imageTokens is a flat estimate for each image, matching a high detail 512px tile image
+	imageTokens = 765
+
+	// truncationMarker is appended to messages that were cut to fit the budget
+	truncationMarker = "\n[…truncated]"
+)
+
+// EstimateTokens roughly estimates the number of tokens in text. It errs on the
+// high side for non-English text since it counts bytes rather than characters.
+func EstimateTokens(text string) int {
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+// EstimateMessageTokens estimates the tokens a single message adds to a prompt
+func EstimateMessageTokens(msg Message) int {
+	tokens := messageOverheadTokens + EstimateTokens(msg.Name) + EstimateTokens(msg.Content)
+	for _, part := range msg.Parts {
+		if part.ImageURL != nil {
+			tokens += imageTokens
+		} else {
+			tokens += EstimateTokens(part.Text)
+		}
+	}
+
+	return tokens
+}
+
+// EstimatePromptTokens estimates the prompt tokens for a full request
+func EstimatePromptTokens(messages []Message) int {
+	total := replyOverheadTokens
+	for _, msg := range messages {
+		total += EstimateMessageTokens(msg)
+	}
+
+	return total
+}
+
+// FitToBudget returns the pinned messages followed by as much of the history as fits
+// within the token budget, along with the estimated prompt tokens. The newest history
+// message is always kept, truncated if it doesn't fit on its own. Older messages are
+// dropped oldest first, and the oldest kept message may be truncated to fill the budget.
+// A budget of zero or less disables truncation.
+func FitToBudget(pinned, history []Message, budget int) ([]Message, int) {
+	messages := make([]Message, 0, len(pinned)+len(history))
+	messages = append(messages, pinned...)
+
+	if budget <= 0 {
+		messages = append(messages, history...)
+		return messages, EstimatePromptTokens(messages)
+	}
+
+	remaining := budget - EstimatePromptTokens(pinned)
+
+	// Walk the history from newest to oldest, keeping what fits
+	kept := make([]Message, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		msg := history[i]
+		cost := EstimateMessageTokens(msg)
+
+		if cost <= remaining {
+			kept = append(kept, msg)
+			remaining -= cost
+			continue
+		}
+
+		// Always keep the newest turn, and fill any worthwhile leftover space with
+		// the start of the next oldest message
+		available := remaining - messageOverheadTokens
+		if i == len(history)-1 && available < minTruncatedTokens {
+			available = minTruncatedTokens
+		}
+		if i == len(history)-1 || available >= minTruncatedTokens {
+			if i != len(history)-1 {
+				// Images in older messages can't be partially kept
+				msg.Parts = nil
+			}
+			msg.Content = truncateToTokens(msg.Content, available)
+			kept = append(kept, msg)
+		}
+		break
+	}
+
+	// Restore chronological order
+	for i := len(kept) - 1; i >= 0; i-- {
+		messages = append(messages, kept[i])
+	}
+
+	return messages, EstimatePromptTokens(messages)
+}
+
+// truncateToTokens cuts text to roughly the given number of tokens on a rune boundary
+func truncateToTokens(text string, tokens int) string {
+	limit := tokens*charsPerToken - len(truncationMarker)
+	if limit <= 0 {
+		return truncationMarker
+	}
+	if len(text) <= limit {
+		return text
+	}
+
+	// Back up to the start of a rune so multi-byte characters aren't split
+	for limit > 0 && !utf8.RuneStart(text[limit]) {
+		limit--
+	}
+
+	return text[:limit] + truncationMarker
+}
+
+// TokenBudget returns the prompt token budget for the client's model
+func (c *Client) TokenBudget() int {
+	// Config keys are case-insensitive, so fall back to the lowercased model name
+	if budget, exists := c.modelTokenBudgets[c.model]; exists {
+		return budget
+	}
+	if budget, exists := c.modelTokenBudgets[strings.ToLower(c.model)]; exists {
+		return budget
+	}
+
+	return c.tokenBudget
+}
+
+// SetTokenBudget sets the default prompt token budget and any per-model overrides
+func (c *Client) SetTokenBudget(defaultBudget int, modelBudgets map[string]int) {
+	c.tokenBudget = defaultBudget
+	c.modelTokenBudgets = modelBudgets
+}
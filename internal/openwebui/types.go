@@ -1,9 +1,105 @@
 package openwebui
 
+import "encoding/json"
+
 // Message represents a message in the OpenWebUI API format
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	Name    string `json:"name,omitempty"`
+
+	// ToolCalls holds the functions an assistant message asked to call, and ToolCallID
+	// links a tool message to the call it answers
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+
+	// Parts holds additional content such as images. When set, the message is sent
+	// with an array of content parts that starts with Content as a text part.
+	Parts []ContentPart `json:"-"`
+}
+
+// ContentPart represents one part of a multimodal message
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL references an image by URL or data URI
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// MarshalJSON sends plain text content as a string and multimodal content as parts
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	if len(m.Parts) == 0 {
+		return json.Marshal(message(m))
+	}
+
+	parts := make([]ContentPart, 0, len(m.Parts)+1)
+	if m.Content != "" {
+		parts = append(parts, ContentPart{Type: "text", Text: m.Content})
+	}
+	parts = append(parts, m.Parts...)
+
+	// The outer Content replaces the embedded one, keeping every other field
+	return json.Marshal(struct {
+		message
+		Content []ContentPart `json:"content"`
+	}{
+		message: message(m),
+		Content: parts,
+	})
+}
+
+// Tool describes a function the model may call
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction is the name, description and JSON schema parameters of a callable function
+type ToolFunction struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+// ToolCall is a function call requested by the model. Streamed calls arrive in pieces
+// that share an Index, with the arguments split across them.
+type ToolCall struct {
+	Index    *int             `json:"index,omitempty"`
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction is the name and JSON encoded arguments of a function call
+type ToolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// NewFunctionTool creates a tool for a function with JSON schema parameters
+func NewFunctionTool(name, description string, parameters any) Tool {
+	return Tool{
+		Type: "function",
+		Function: ToolFunction{
+			Name:        name,
+			Description: description,
+			Parameters:  parameters,
+		},
+	}
+}
+
+// NewImagePart creates a content part for an image URL or data URI
+func NewImagePart(url string) ContentPart {
+	return ContentPart{
+		Type:     "image_url",
+		ImageURL: &ImageURL{URL: url},
+	}
 }
 
 // ChatCompletionRequest represents a request to the OpenWebUI chat completion API
@@ -11,6 +107,24 @@ type ChatCompletionRequest struct {
 	Model    string    `json:"model"`
 	ToolIDs  []string  `json:"tool_ids"`
 	Messages []Message `json:"messages"`
+	Tools    []Tool    `json:"tools,omitempty"`
+	Stream   bool      `json:"stream,omitempty"`
+
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+
+	GenerationParams
+}
+
+// GenerationParams holds optional sampling settings. Unset fields use the model's defaults.
+type GenerationParams struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+}
+
+// StreamOptions controls what a streaming response includes
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // ChatCompletionResponse represents a response from the OpenWebUI chat completion API
@@ -30,6 +144,32 @@ type Choice struct {
 	FinishReason string  `json:"finish_reason"`
 }
 
+// ChatCompletionChunk represents a single server-sent event from a streamed chat completion
+type ChatCompletionChunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+	Usage   *Usage        `json:"usage,omitempty"`
+}
+
+// ChunkChoice represents a completion choice delta in a streamed response
+type ChunkChoice struct {
+	Index        int     `json:"index"`
+	Delta        Message `json:"delta"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+// StreamDelta carries a piece of streamed completion text, the tool calls or usage
+// reported at the end of the stream, or the error that ended the stream
+type StreamDelta struct {
+	Content   string
+	ToolCalls []ToolCall
+	Usage     *Usage
+	Err       error
+}
+
 // Usage represents token usage information in the OpenWebUI API response
 type Usage struct {
 	PromptTokens     int `json:"prompt_tokens"`
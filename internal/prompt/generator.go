@@ -19,7 +19,8 @@ const (
 	ActionFile      ActionType = "file"
 )
 
-// ActionDescription contains detailed information about an action
+// ActionDescription explains an action to the model, as markup documentation and as the
+// schema of the tool that performs it
 type ActionDescription struct {
 	Type          ActionType
 	Description   string
@@ -27,94 +28,32 @@ type ActionDescription struct {
 	Examples      []string
 	Limitations   string
 	BestPractices string
-}
-
-// GetActionDescriptions returns detailed descriptions for all available actions
-func GetActionDescriptions() []ActionDescription {
-	return []ActionDescription{
-		{
-			Type:        ActionStatus,
-			Description: "Changes the bot's status message displayed in Discord.",
-			Parameters:  "A single string representing the status text to display.",
-			Examples: []string{
-				"[ACTION:status|Playing chess]",
-				"[ACTION:status|Listening to music]",
-				"[ACTION:status|Watching tutorials]",
-			},
-			Limitations:   "Status changes may not be immediately visible to all users due to Discord's caching.",
-			BestPractices: "Keep status messages concise and relevant to the current conversation or bot's purpose.",
-		},
-		{
-			Type:        ActionSilence,
-			Description: "Not all messages are for the bot. If it is not appropriate to respond, include this action.",
-			Parameters:  "boolean",
-			Examples: []string{
-				"[ACTION:silence|true]",
-			},
-			Limitations:   "None. This should be used generously.",
-			BestPractices: "Unless addressed directly or something bot can help please use this to stay silent.",
-		},
-		{
-			Type:        ActionReact,
-			Description: "Adds a single emoji reaction to the user's message.",
-			Parameters:  "A single emoji (Unicode emoji or Discord custom emoji ID).",
-			Examples: []string{
-				"[ACTION:react|👍]",
-				"[ACTION:react|❤️]",
-				"[ACTION:react|🎉]",
-			},
-			Limitations:   "Some custom emojis may only work if the bot has access to the server they're from.",
-			BestPractices: "Use reactions to acknowledge user messages or provide quick feedback without sending a text response.",
-		},
-		{
-			Type:        ActionFormat,
-			Description: "Applies special formatting to the bot's message.",
-			Parameters:  "Format type followed by content, separated by '|'. Format types include: code, bold, italic, quote.",
-			Examples: []string{
-				"[ACTION:format|code:python|print(\"Hello World\")]",
-				"[ACTION:format|bold|Important information]",
-				"[ACTION:format|italic|Emphasized text]",
-				"[ACTION:format|quote|This is a quote]",
-			},
-			Limitations:   "Formatting may not be combined (e.g., can't have bold and italic together).",
-			BestPractices: "Use code formatting when sharing code snippets to improve readability.",
-		},
-		{
-			Type:        ActionReactions,
-			Description: "Adds multiple emoji reactions in sequence to the user's message.",
-			Parameters:  "Multiple emojis separated by '|'.",
-			Examples: []string{
-				"[ACTION:reactions|👍|❤️|🎉]",
-				"[ACTION:reactions|1️⃣|2️⃣|3️⃣]",
-			},
-			Limitations:   "Limited to a reasonable number of reactions to avoid rate limiting.",
-			BestPractices: "Use sequential reactions for creating simple polls or showing a sequence of emotions.",
-		},
-	}
+	// Schema is the JSON schema of the action's tool call arguments
+	Schema map[string]any
 }
 
 // GenerateSystemPrompt creates a comprehensive system prompt with action descriptions
-func GenerateSystemPrompt(basePrompt string) string {
+func GenerateSystemPrompt(basePrompt string, actions []ActionDescription) string {
 	var sb strings.Builder
 
 	// Add base prompt
-	if basePrompt != "" {
-		sb.WriteString(basePrompt)
-		sb.WriteString("\n\n")
-	} else {
-		sb.WriteString("You are a helpful Discord bot assistant. You can respond to user queries and perform special actions.\n\n")
+	writeBasePrompt(&sb, basePrompt)
+	if len(actions) == 0 {
+		return sb.String()
 	}
 
 	// Add action format description
 	sb.WriteString("# SPECIAL ACTIONS\n\n")
 	sb.WriteString("You can perform special actions by including action markup in your responses using this format:\n")
 	sb.WriteString("```\n[ACTION:action_type|action_parameters]\n```\n\n")
+	sb.WriteString("Parameters may span several lines and contain balanced brackets. Escape any other bracket as \\[ or \\], and a backslash as \\\\. ")
+	sb.WriteString("Markup inside fenced code blocks is shown as written instead of being performed.\n\n")
 	sb.WriteString("Always include a normal text response along with any actions to explain what you're doing.\n\n")
 
 	// Add detailed action descriptions
 	sb.WriteString("## Available Actions\n\n")
 
-	for _, action := range GetActionDescriptions() {
+	for _, action := range actions {
 		// Action header
 		sb.WriteString(fmt.Sprintf("### %s\n", strings.ToUpper(string(action.Type))))
 
@@ -124,11 +63,12 @@ func GenerateSystemPrompt(basePrompt string) string {
 		// Parameters
 		sb.WriteString(fmt.Sprintf("**Parameters:** %s\n\n", action.Parameters))
 
-		// Examples
+		// Examples are left unfenced, since markup in fences isn't performed
 		sb.WriteString("**Examples:**\n")
 		for _, example := range action.Examples {
-			sb.WriteString(fmt.Sprintf("```\n%s\n```\n", example))
+			sb.WriteString(fmt.Sprintf("- %s\n", example))
 		}
+		sb.WriteString("\n")
 
 		// Limitations
 		sb.WriteString(fmt.Sprintf("**Limitations:** %s\n\n", action.Limitations))
@@ -146,8 +86,18 @@ func GenerateSystemPrompt(basePrompt string) string {
 
 	// Add example of combined usage
 	sb.WriteString("## Combined Usage Example\n\n")
-	sb.WriteString("```\n[ACTION:status|Helping with code]\n[ACTION:react|💻]\nHere's the Python code you requested:\n\n[ACTION:format|code:python|def hello_world():\n    print(\"Hello, World!\")]\n\n[ACTION:pin|Important code example]\n```\n\n")
-	sb.WriteString("This example changes the bot's status, adds a reaction, formats code, and pins the message.\n")
+	sb.WriteString("This response changes the bot's status, adds a reaction, formats code, and pins the message. Write actions like this, outside any code block:\n\n")
+	sb.WriteString("[ACTION:status|Helping with code]\n[ACTION:react|💻]\nHere's the Python code you requested:\n\n[ACTION:format|code:python|def hello_world():\n    print(\"Hello, World!\")]\n\n[ACTION:pin|Important code example]\n")
 
 	return sb.String()
 }
+
+// writeBasePrompt writes the persona, or a generic one when it is empty
+func writeBasePrompt(sb *strings.Builder, basePrompt string) {
+	if basePrompt != "" {
+		sb.WriteString(basePrompt)
+		sb.WriteString("\n\n")
+	} else {
+		sb.WriteString("You are a helpful Discord bot assistant. You can respond to user queries and perform special actions.\n\n")
+	}
+}
@@ -0,0 +1,45 @@
+package prompt
+
+import "strings"
+
+// GenerateToolSystemPrompt creates a system prompt for models that perform actions through
+// tool calls, so it leaves out the action markup instructions
+func GenerateToolSystemPrompt(basePrompt string, actions []ActionDescription) string {
+	var sb strings.Builder
+
+	writeBasePrompt(&sb, basePrompt)
+	if len(actions) == 0 {
+		return sb.String()
+	}
+
+	sb.WriteString("# SPECIAL ACTIONS\n\n")
+	sb.WriteString("You can perform Discord actions, such as reacting to messages or staying silent, by calling the provided tools. ")
+	sb.WriteString("Each tool describes what it does. After calling tools you'll get their results and can then write your reply.\n\n")
+	sb.WriteString("## General Guidelines\n\n")
+	sb.WriteString("1. **Combine actions with text responses** - Unless you choose to stay silent, always write a normal text reply as well.\n")
+	sb.WriteString("2. **Rate limits** - Use actions judiciously to avoid hitting Discord's rate limits.\n")
+	sb.WriteString("3. **Permissions** - Some actions require specific permissions, and a tool result will tell you when an action wasn't allowed.\n")
+
+	return sb.String()
+}
+
+// ObjectSchema builds the JSON schema of an object with the given properties
+func ObjectSchema(properties map[string]any, required ...string) map[string]any {
+	schema := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+// StringSchema builds the JSON schema of a described string
+func StringSchema(description string) map[string]any {
+	return map[string]any{
+		"type":        "string",
+		"description": description,
+	}
+}
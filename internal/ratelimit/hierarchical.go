@@ -0,0 +1,187 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// idleLimiterCleanupInterval is how often limiters that have refilled completely are dropped
+const idleLimiterCleanupInterval = 10 * time.Minute
+
+// Level is a level of the rate limit hierarchy
+type Level string
+
+const (
+	LevelGlobal  Level = "global"
+	LevelGuild   Level = "guild"
+	LevelChannel Level = "channel"
+	LevelUser    Level = "user"
+)
+
+// Limits configures the requests per minute allowed at each level. Zero disables a level.
+type Limits struct {
+	Global  int
+	Guild   int
+	Channel int
+	User    int
+	// Burst caps how many requests the global level allows at once, or a minute's worth when zero
+	Burst int
+}
+
+// GlobalLimiter creates a standalone limiter with the global rate and burst
+func (l Limits) GlobalLimiter() *Limiter {
+	if l.Burst > 0 {
+		return NewLimiterWithBurst(l.Global, l.Burst)
+	}
+
+	return NewLimiter(l.Global)
+}
+
+// Key identifies where a request comes from. An empty guild ID skips the guild level.
+type Key struct {
+	GuildID   string
+	ChannelID string
+	UserID    string
+}
+
+// Rejection describes the level that rejected a request and when it can be retried
+type Rejection struct {
+	Level      Level
+	RetryAfter time.Duration
+}
+
+// HierarchicalLimiter applies global, per-guild, per-channel and per-user limits together.
+// A request is only counted when every level allows it.
+type HierarchicalLimiter struct {
+	limits      Limits
+	global      *Limiter
+	guilds      map[string]*Limiter
+	channels    map[string]*Limiter
+	users       map[string]*Limiter
+	lastCleanup time.Time
+	mutex       sync.Mutex
+}
+
+// NewHierarchicalLimiter creates a new hierarchical rate limiter
+func NewHierarchicalLimiter(limits Limits) *HierarchicalLimiter {
+	limiter := &HierarchicalLimiter{
+		limits:      limits,
+		guilds:      make(map[string]*Limiter),
+		channels:    make(map[string]*Limiter),
+		users:       make(map[string]*Limiter),
+		lastCleanup: time.Now(),
+	}
+
+	if limits.Global > 0 {
+		limiter.global = limits.GlobalLimiter()
+	}
+
+	return limiter
+}
+
+// Allow checks every level for the request and consumes a token from each of them if all
+// allow it. Otherwise it returns the rejection with the longest wait, so retrying after
+// it passes a single check at every level.
+func (h *HierarchicalLimiter) Allow(key Key) (bool, *Rejection) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.cleanup()
+
+	type leveled struct {
+		level   Level
+		limiter *Limiter
+	}
+
+	var levels []leveled
+	if h.global != nil {
+		levels = append(levels, leveled{LevelGlobal, h.global})
+	}
+	if key.GuildID != "" {
+		if limiter := keyedLimiter(h.guilds, key.GuildID, h.limits.Guild); limiter != nil {
+			levels = append(levels, leveled{LevelGuild, limiter})
+		}
+	}
+	if limiter := keyedLimiter(h.channels, key.ChannelID, h.limits.Channel); limiter != nil {
+		levels = append(levels, leveled{LevelChannel, limiter})
+	}
+	if limiter := keyedLimiter(h.users, key.UserID, h.limits.User); limiter != nil {
+		levels = append(levels, leveled{LevelUser, limiter})
+	}
+
+	// Lock every level so the check and the consumption happen together
+	for _, l := range levels {
+		l.limiter.mutex.Lock()
+		defer l.limiter.mutex.Unlock()
+	}
+
+	var rejection *Rejection
+	for _, l := range levels {
+		wait := l.limiter.retryAfter()
+		if l.limiter.tokens >= 1 {
+			continue
+		}
+		if rejection == nil || wait > rejection.RetryAfter {
+			rejection = &Rejection{Level: l.level, RetryAfter: wait}
+		}
+	}
+	if rejection != nil {
+		for _, l := range levels {
+			l.limiter.stats.rejected++
+		}
+		return false, rejection
+	}
+
+	for _, l := range levels {
+		l.limiter.tokens--
+		l.limiter.stats.allowed++
+	}
+
+	return true, nil
+}
+
+// GlobalStats returns a snapshot of the global level, which is empty when it is disabled
+func (h *HierarchicalLimiter) GlobalStats() Stats {
+	if h.global == nil {
+		return Stats{}
+	}
+
+	return h.global.Stats()
+}
+
+// keyedLimiter returns the limiter for a key, creating it if needed. It returns nil when
+// the level is disabled or the key is empty.
+func keyedLimiter(limiters map[string]*Limiter, key string, requestsPerMinute int) *Limiter {
+	if requestsPerMinute <= 0 || key == "" {
+		return nil
+	}
+
+	limiter, exists := limiters[key]
+	if !exists {
+		limiter = NewLimiter(requestsPerMinute)
+		limiters[key] = limiter
+	}
+
+	return limiter
+}
+
+// cleanup drops limiters that have refilled completely, since a new limiter behaves the
+// same. The caller must hold the mutex.
+func (h *HierarchicalLimiter) cleanup() {
+	if time.Since(h.lastCleanup) < idleLimiterCleanupInterval {
+		return
+	}
+	h.lastCleanup = time.Now()
+
+	for _, limiters := range []map[string]*Limiter{h.guilds, h.channels, h.users} {
+		for key, limiter := range limiters {
+			limiter.mutex.Lock()
+			full := limiter.full()
+			limiter.mutex.Unlock()
+
+			if full {
+				delete(limiters, key)
+			}
+		}
+	}
+}
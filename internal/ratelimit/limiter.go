@@ -1,6 +1,10 @@
 package ratelimit
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -8,32 +12,38 @@ import (
 	"go.uber.org/zap"
 )
 
-// Limiter implements a token bucket rate limiter
+// Limiter implements a token bucket rate limiter. Tokens refill continuously, so partial
+// tokens carry over between checks, and the bucket holds up to burst tokens.
 type Limiter struct {
-	tokens         int
-	maxTokens      int
-	refillRate     int
-	refillInterval time.Duration
-	lastRefill     time.Time
-	mutex          sync.Mutex
+	rate       float64 // tokens per second
+	burst      int
+	tokens     float64
+	lastRefill time.Time
+	stats      stats
+	mutex      sync.Mutex
+	// now returns the current time, so tests can control the clock
+	now func() time.Time
 }
 
-// NewLimiter creates a new rate limiter
+// NewLimiter creates a new rate limiter that allows a burst of a full minute of requests
 func NewLimiter(requestsPerMinute int) *Limiter {
-	// Convert requests per minute to tokens and refill rate
-	maxTokens := requestsPerMinute
-	refillRate := requestsPerMinute
-	refillInterval := time.Minute
+	return NewLimiterWithBurst(requestsPerMinute, requestsPerMinute)
+}
 
-	limiter := &Limiter{
-		tokens:         maxTokens,
-		maxTokens:      maxTokens,
-		refillRate:     refillRate,
-		refillInterval: refillInterval,
-		lastRefill:     time.Now(),
+// NewLimiterWithBurst creates a new rate limiter that refills at requestsPerMinute and
+// holds at most burst tokens
+func NewLimiterWithBurst(requestsPerMinute, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
 	}
 
-	return limiter
+	return &Limiter{
+		rate:       float64(requestsPerMinute) / 60,
+		burst:      burst,
+		tokens:     float64(burst),
+		lastRefill: time.Now(),
+		now:        time.Now,
+	}
 }
 
 // Allow checks if a request is allowed and consumes a token if it is
@@ -41,87 +51,209 @@ func (l *Limiter) Allow() bool {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
-	// Refill tokens based on time elapsed
-	l.refill()
+	l.refill(l.now())
 
-	// Check if we have tokens available
-	if l.tokens > 0 {
+	if l.tokens >= 1 {
 		l.tokens--
+		l.stats.allowed++
 		return true
 	}
 
+	l.stats.rejected++
 	return false
 }
 
-// refill adds tokens based on time elapsed since last refill
-func (l *Limiter) refill() {
-	now := time.Now()
-	elapsed := now.Sub(l.lastRefill)
+// refill adds the tokens earned since the last refill, keeping partial tokens.
+// The caller must hold the mutex.
+func (l *Limiter) refill(now time.Time) {
+	if elapsed := now.Sub(l.lastRefill); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rate
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+	}
+	l.lastRefill = now
+}
+
+// Reservation is a token taken ahead of time that can be used once its delay has passed
+type Reservation struct {
+	limiter *Limiter
+	readyAt time.Time
+	// never is set when the limiter doesn't refill, so the token will never be ready
+	never    bool
+	canceled bool
+}
+
+// Reserve takes a token now, going into debt if none are available, and returns a
+// reservation saying how long to wait before acting on it
+func (l *Limiter) Reserve() *Reservation {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 
-	// Calculate how many tokens to add based on elapsed time
-	intervalsElapsed := float64(elapsed) / float64(l.refillInterval)
-	tokensToAdd := int(intervalsElapsed * float64(l.refillRate))
+	now := l.now()
+	l.refill(now)
+	l.tokens--
 
-	if tokensToAdd > 0 {
-		l.tokens = min(l.maxTokens, l.tokens+tokensToAdd)
-		l.lastRefill = now
+	reservation := &Reservation{limiter: l, readyAt: now}
+	if l.tokens < 0 {
+		if l.rate <= 0 {
+			reservation.never = true
+		} else {
+			reservation.readyAt = now.Add(l.durationFor(-l.tokens))
+		}
 	}
+
+	return reservation
+}
+
+// Delay returns how long to wait before acting on the reservation
+func (r *Reservation) Delay() time.Duration {
+	if r.never {
+		return time.Duration(math.MaxInt64)
+	}
+	if delay := r.readyAt.Sub(r.limiter.now()); delay > 0 {
+		return delay
+	}
+
+	return 0
 }
 
-// Wait blocks until a token is available and then consumes it
-func (l *Limiter) Wait() {
-	for {
-		l.mutex.Lock()
-		l.refill()
+// Cancel gives the token back, for reservations that won't be acted on
+func (r *Reservation) Cancel() {
+	r.limiter.mutex.Lock()
+	defer r.limiter.mutex.Unlock()
 
-		if l.tokens > 0 {
-			l.tokens--
-			l.mutex.Unlock()
-			return
+	if r.canceled {
+		return
+	}
+	r.canceled = true
+
+	r.limiter.refill(r.limiter.now())
+	r.limiter.tokens++
+	if r.limiter.tokens > float64(r.limiter.burst) {
+		r.limiter.tokens = float64(r.limiter.burst)
+	}
+}
+
+// Wait blocks until a token is available and then consumes it. It returns an error
+// without consuming a token if ctx is done first, its deadline is too soon or the
+// limiter never refills.
+func (l *Limiter) Wait(ctx context.Context) error {
+	return wait(ctx, l.Reserve())
+}
+
+// wait blocks until every reservation is ready. If any of them can't be waited for, it
+// cancels them all so no limiter loses a token to a request that never happens.
+func wait(ctx context.Context, reservations ...*Reservation) error {
+	var delay time.Duration
+	for _, reservation := range reservations {
+		delay = max(delay, reservation.Delay())
+	}
+
+	fail := func(err error) error {
+		for _, reservation := range reservations {
+			reservation.Cancel()
+			reservation.limiter.recordRejection()
 		}
+		return err
+	}
+	succeed := func() error {
+		for _, reservation := range reservations {
+			reservation.limiter.recordWait(delay)
+		}
+		return nil
+	}
+
+	if delay == 0 {
+		return succeed()
+	}
+
+	for _, reservation := range reservations {
+		if reservation.never {
+			return fail(errors.New("rate limit is exhausted and doesn't refill"))
+		}
+	}
 
-		// Calculate time until next token is available
-		timeToNextToken := l.refillInterval / time.Duration(l.refillRate)
-		l.mutex.Unlock()
+	// Give up straight away if the deadline will pass before the token is ready
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return fail(fmt.Errorf("rate limit wait of %s exceeds context deadline", delay))
+	}
+
+	logger.Debug("Rate limit reached, waiting",
+		zap.Duration("wait_time", delay),
+	)
 
-		logger.Debug("Rate limit reached, waiting",
-			zap.Duration("wait_time", timeToNextToken),
-		)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
 
-		// Wait a bit before trying again
-		time.Sleep(timeToNextToken)
+	select {
+	case <-timer.C:
+		return succeed()
+	case <-ctx.Done():
+		return fail(fmt.Errorf("rate limit wait cancelled: %w", ctx.Err()))
 	}
 }
 
-// RemainingTokens returns the number of tokens currently available
-func (l *Limiter) RemainingTokens() int {
+// RetryAfter returns how long until a token is available, or zero if one is available now
+func (l *Limiter) RetryAfter() time.Duration {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
-	l.refill()
-	return l.tokens
+	return l.retryAfter()
+}
+
+// retryAfter returns how long until a token is available. The caller must hold the mutex.
+func (l *Limiter) retryAfter() time.Duration {
+	l.refill(l.now())
+
+	if l.tokens >= 1 {
+		return 0
+	}
+
+	return l.durationFor(1 - l.tokens)
+}
+
+// durationFor returns how long it takes to earn a number of tokens
+func (l *Limiter) durationFor(tokens float64) time.Duration {
+	if l.rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Duration(tokens / l.rate * float64(time.Second))
 }
 
-// min returns the minimum of two integers
-func min(a, b int) int {
-	if a < b {
-		return a
+// RemainingTokens returns the number of whole tokens currently available
+func (l *Limiter) RemainingTokens() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.refill(l.now())
+	if l.tokens < 0 {
+		return 0
 	}
-	return b
+	return int(l.tokens)
+}
+
+// full reports whether the bucket has refilled completely. The caller must hold the mutex.
+func (l *Limiter) full() bool {
+	l.refill(l.now())
+	return l.tokens >= float64(l.burst)
 }
 
 // ChannelLimiter manages rate limits for multiple channels
 type ChannelLimiter struct {
-	limiters      map[string]*Limiter
-	globalLimiter *Limiter
-	mutex         sync.RWMutex
+	limiters                 map[string]*Limiter
+	globalLimiter            *Limiter
+	channelRequestsPerMinute int
+	mutex                    sync.RWMutex
 }
 
 // NewChannelLimiter creates a new channel-based rate limiter
 func NewChannelLimiter(globalRequestsPerMinute, channelRequestsPerMinute int) *ChannelLimiter {
 	return &ChannelLimiter{
-		limiters:      make(map[string]*Limiter),
-		globalLimiter: NewLimiter(globalRequestsPerMinute),
+		limiters:                 make(map[string]*Limiter),
+		globalLimiter:            NewLimiter(globalRequestsPerMinute),
+		channelRequestsPerMinute: channelRequestsPerMinute,
 	}
 }
 
@@ -133,30 +265,18 @@ func (cl *ChannelLimiter) Allow(channelID string) bool {
 	}
 
 	// Then check channel-specific rate limit
-	cl.mutex.RLock()
-	limiter, exists := cl.limiters[channelID]
-	cl.mutex.RUnlock()
-
-	if !exists {
-		cl.mutex.Lock()
-		// Check again in case another goroutine created it while we were waiting for the lock
-		limiter, exists = cl.limiters[channelID]
-		if !exists {
-			limiter = NewLimiter(10) // Default to 10 requests per minute per channel
-			cl.limiters[channelID] = limiter
-		}
-		cl.mutex.Unlock()
-	}
-
-	return limiter.Allow()
+	return cl.limiter(channelID).Allow()
 }
 
-// Wait blocks until a request for a specific channel is allowed
-func (cl *ChannelLimiter) Wait(channelID string) {
-	// First wait for global rate limit
-	cl.globalLimiter.Wait()
+// Wait blocks until a request for a specific channel is allowed or ctx is done. Tokens
+// are reserved from the global and channel limits together and both are given back if
+// the wait fails.
+func (cl *ChannelLimiter) Wait(ctx context.Context, channelID string) error {
+	return wait(ctx, cl.globalLimiter.Reserve(), cl.limiter(channelID).Reserve())
+}
 
-	// Then wait for channel-specific rate limit
+// limiter returns the limiter for a channel, creating it if needed
+func (cl *ChannelLimiter) limiter(channelID string) *Limiter {
 	cl.mutex.RLock()
 	limiter, exists := cl.limiters[channelID]
 	cl.mutex.RUnlock()
@@ -166,11 +286,11 @@ func (cl *ChannelLimiter) Wait(channelID string) {
 		// Check again in case another goroutine created it while we were waiting for the lock
 		limiter, exists = cl.limiters[channelID]
 		if !exists {
-			limiter = NewLimiter(10) // Default to 10 requests per minute per channel
+			limiter = NewLimiter(cl.channelRequestsPerMinute)
 			cl.limiters[channelID] = limiter
 		}
 		cl.mutex.Unlock()
 	}
 
-	limiter.Wait()
-}
\ No newline at end of file
+	return limiter
+}
@@ -0,0 +1,201 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/justmiles/openwebui-discord/internal/config"
+	"github.com/justmiles/openwebui-discord/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.Init(config.DefaultConfig()); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// fakeClock is a clock that only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time              { return c.now }
+func (c *fakeClock) Advance(delta time.Duration) { c.now = c.now.Add(delta) }
+
+// newTestLimiter creates a limiter that reads the time from clock
+func newTestLimiter(clock *fakeClock, requestsPerMinute, burst int) *Limiter {
+	limiter := NewLimiterWithBurst(requestsPerMinute, burst)
+	limiter.now = clock.Now
+	limiter.lastRefill = clock.Now()
+	return limiter
+}
+
+func TestLimiterFractionalRefill(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	limiter := newTestLimiter(clock, 60, 1)
+
+	if !limiter.Allow() {
+		t.Fatal("first request was rejected")
+	}
+
+	// Half a token is kept rather than lost on each check
+	for i := 0; i < 2; i++ {
+		clock.Advance(400 * time.Millisecond)
+		if limiter.Allow() {
+			t.Fatalf("request %d allowed with a partial token", i)
+		}
+	}
+
+	clock.Advance(200 * time.Millisecond)
+	if !limiter.Allow() {
+		t.Fatal("request rejected after partial tokens added up to one")
+	}
+
+	if got := limiter.RetryAfter(); got != time.Second {
+		t.Errorf("RetryAfter = %s, want 1s", got)
+	}
+}
+
+func TestLimiterBurstSeparateFromRate(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	limiter := newTestLimiter(clock, 60, 3)
+
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow() {
+			t.Fatalf("request %d within the burst was rejected", i)
+		}
+	}
+	if limiter.Allow() {
+		t.Fatal("request past the burst was allowed")
+	}
+
+	// A long idle period refills at most the burst
+	clock.Advance(time.Hour)
+	if got := limiter.RemainingTokens(); got != 3 {
+		t.Errorf("RemainingTokens = %d, want 3", got)
+	}
+
+	stats := limiter.Stats()
+	if stats.Burst != 3 || stats.RequestsPerMinute != 60 {
+		t.Errorf("stats burst %d at %v per minute, want 3 at 60", stats.Burst, stats.RequestsPerMinute)
+	}
+	if stats.Allowed != 3 || stats.Rejected != 1 {
+		t.Errorf("stats allowed %d, rejected %d, want 3 and 1", stats.Allowed, stats.Rejected)
+	}
+}
+
+func TestReservationCancel(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	limiter := newTestLimiter(clock, 60, 1)
+
+	first := limiter.Reserve()
+	if got := first.Delay(); got != 0 {
+		t.Errorf("first delay = %s, want 0", got)
+	}
+
+	second := limiter.Reserve()
+	if got := second.Delay(); got != time.Second {
+		t.Errorf("second delay = %s, want 1s", got)
+	}
+
+	// Cancelling twice only gives one token back
+	second.Cancel()
+	second.Cancel()
+	clock.Advance(time.Second)
+	if got := limiter.RemainingTokens(); got != 1 {
+		t.Errorf("RemainingTokens = %d, want 1", got)
+	}
+
+	clock.Advance(30 * time.Second)
+	if got := limiter.Reserve().Delay(); got != 0 {
+		t.Errorf("delay after refill = %s, want 0", got)
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+
+	t.Run("deadline too soon gives the token back", func(t *testing.T) {
+		limiter := newTestLimiter(clock, 60, 1)
+		limiter.Allow()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		if err := limiter.Wait(ctx); err == nil {
+			t.Fatal("Wait succeeded past the deadline")
+		}
+		clock.Advance(time.Second)
+		if got := limiter.RemainingTokens(); got != 1 {
+			t.Errorf("RemainingTokens = %d, want 1", got)
+		}
+		if got := limiter.Stats().Rejected; got != 1 {
+			t.Errorf("rejected = %d, want 1", got)
+		}
+	})
+
+	t.Run("no refill fails without a deadline", func(t *testing.T) {
+		limiter := newTestLimiter(clock, 0, 1)
+		limiter.Allow()
+
+		done := make(chan error, 1)
+		go func() { done <- limiter.Wait(context.Background()) }()
+
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Fatal("Wait succeeded on a limiter that doesn't refill")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Wait blocked on a limiter that doesn't refill")
+		}
+	})
+
+	t.Run("histogram counts waits", func(t *testing.T) {
+		// 10,000 tokens a second, so the second wait takes 100µs
+		limiter := newTestLimiter(clock, 600000, 1)
+
+		for i := 0; i < 2; i++ {
+			if err := limiter.Wait(context.Background()); err != nil {
+				t.Fatalf("wait %d: %v", i, err)
+			}
+		}
+
+		stats := limiter.Stats()
+		if stats.Allowed != 2 {
+			t.Errorf("allowed = %d, want 2", stats.Allowed)
+		}
+		if len(stats.WaitHistogram) != len(waitBuckets)+1 {
+			t.Fatalf("histogram has %d buckets, want %d", len(stats.WaitHistogram), len(waitBuckets)+1)
+		}
+		if got := stats.WaitHistogram[0].Count; got != 1 {
+			t.Errorf("waits without delay = %d, want 1", got)
+		}
+		if got := stats.WaitHistogram[1]; got.UpperBound != 100*time.Millisecond || got.Count != 1 {
+			t.Errorf("second bucket = %+v, want one wait up to 100ms", got)
+		}
+	})
+}
+
+func TestChannelLimiterWaitReturnsGlobalToken(t *testing.T) {
+	limiter := NewChannelLimiter(60, 1)
+
+	if err := limiter.Wait(context.Background(), "channel"); err != nil {
+		t.Fatalf("first wait: %v", err)
+	}
+
+	// The channel is exhausted, so this wait fails and must not keep the global token
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, "channel"); err == nil {
+		t.Fatal("wait on an exhausted channel succeeded")
+	}
+
+	if got := limiter.globalLimiter.RemainingTokens(); got != 59 {
+		t.Errorf("global tokens = %d, want 59", got)
+	}
+}
@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// waitBuckets are the upper bounds of the wait time histogram buckets
+var waitBuckets = [...]time.Duration{
+	0,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	15 * time.Second,
+	30 * time.Second,
+	time.Minute,
+}
+
+// stats counts a limiter's decisions and waits. It is guarded by the limiter's mutex.
+type stats struct {
+	allowed  uint64
+	rejected uint64
+	waits    [len(waitBuckets) + 1]uint64 // the last bucket counts longer waits
+}
+
+// Stats is a snapshot of a limiter's state and history
+type Stats struct {
+	// TokensRemaining is how many requests can be made right now, including partial tokens
+	TokensRemaining   float64
+	Burst             int
+	RequestsPerMinute float64
+	// Allowed counts requests let through by Allow or Wait
+	Allowed uint64
+	// Rejected counts requests turned away by Allow and waits that gave up
+	Rejected uint64
+	// WaitHistogram counts how long successful waits took
+	WaitHistogram []WaitBucket
+}
+
+// WaitBucket counts waits that took at most UpperBound and longer than the previous
+// bucket's bound. The last bucket's bound is math.MaxInt64, covering every longer wait.
+type WaitBucket struct {
+	UpperBound time.Duration
+	Count      uint64
+}
+
+// recordWait counts a request that was let through after waiting
+func (l *Limiter) recordWait(wait time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.stats.allowed++
+
+	for i, bound := range waitBuckets {
+		if wait <= bound {
+			l.stats.waits[i]++
+			return
+		}
+	}
+	l.stats.waits[len(waitBuckets)]++
+}
+
+// recordRejection counts a wait that gave up
+func (l *Limiter) recordRejection() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.stats.rejected++
+}
+
+// Stats returns a snapshot of the limiter's state and history
+func (l *Limiter) Stats() Stats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.refill(l.now())
+
+	histogram := make([]WaitBucket, 0, len(l.stats.waits))
+	for i, count := range l.stats.waits {
+		bound := time.Duration(math.MaxInt64)
+		if i < len(waitBuckets) {
+			bound = waitBuckets[i]
+		}
+		histogram = append(histogram, WaitBucket{UpperBound: bound, Count: count})
+	}
+
+	return Stats{
+		TokensRemaining:   l.tokens,
+		Burst:             l.burst,
+		RequestsPerMinute: l.rate * 60,
+		Allowed:           l.stats.allowed,
+		Rejected:          l.stats.rejected,
+		WaitHistogram:     histogram,
+	}
+}